@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// AdjustConfig holds the output tone and sharpening adjustments
+type AdjustConfig struct {
+	Brightness float64 // -100 to 100
+	Contrast   float64 // -100 to 100
+	Saturation float64 // -100 to 100
+	Tone       string  // "none", "grayscale" or "sepia"
+
+	// Unsharp mask
+	SharpenAmount    float64 // percent, 0 disables sharpening
+	SharpenRadius    float64 // gaussian sigma in pixels
+	SharpenThreshold int     // minimum difference to sharpen, 0-255
+}
+
+// applyAdjustments applies tone adjustments and sharpening after resizing.
+// It runs before the watermark so the mark keeps its configured color.
+func applyAdjustments(img image.Image, ctx *ImageContext) image.Image {
+	cfg := appData.Adjust
+	adjusted := img
+	changed := false
+
+	if cfg.Brightness != 0 {
+		adjusted = imaging.AdjustBrightness(adjusted, cfg.Brightness)
+		changed = true
+	}
+	if cfg.Contrast != 0 {
+		adjusted = imaging.AdjustContrast(adjusted, cfg.Contrast)
+		changed = true
+	}
+	if cfg.Saturation != 0 {
+		adjusted = imaging.AdjustSaturation(adjusted, cfg.Saturation)
+		changed = true
+	}
+
+	switch cfg.Tone {
+	case "grayscale":
+		adjusted = imaging.Grayscale(adjusted)
+		changed = true
+	case "sepia":
+		adjusted = imaging.AdjustFunc(adjusted, sepia)
+		changed = true
+	}
+
+	if cfg.SharpenAmount > 0 && cfg.SharpenRadius > 0 {
+		adjusted = unsharpMask(adjusted, cfg.SharpenAmount/100, cfg.SharpenRadius, cfg.SharpenThreshold)
+		changed = true
+	}
+
+	if changed {
+		ctx.Logf("adjusted brightness=%.0f contrast=%.0f saturation=%.0f tone=%s sharpen=%.0f%%/%.1f/%d",
+			cfg.Brightness, cfg.Contrast, cfg.Saturation, cfg.Tone, cfg.SharpenAmount, cfg.SharpenRadius, cfg.SharpenThreshold)
+	}
+	return adjusted
+}
+
+// sepia maps a pixel to the classic sepia tone
+func sepia(c color.NRGBA) color.NRGBA {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	clamp := func(v float64) uint8 {
+		return uint8(math.Min(255, v+0.5))
+	}
+	return color.NRGBA{
+		R: clamp(0.393*r + 0.769*g + 0.189*b),
+		G: clamp(0.349*r + 0.686*g + 0.168*b),
+		B: clamp(0.272*r + 0.534*g + 0.131*b),
+		A: c.A,
+	}
+}
+
+// unsharpMask sharpens by adding back the difference to a blurred copy
+// wherever that difference exceeds the threshold
+func unsharpMask(img image.Image, amount, radius float64, threshold int) *image.NRGBA {
+	src := imaging.Clone(img)
+	blurred := imaging.Blur(src, radius)
+	for i := 0; i < len(src.Pix); i++ {
+		if i%4 == 3 {
+			continue // leave alpha alone
+		}
+		diff := int(src.Pix[i]) - int(blurred.Pix[i])
+		if diff < threshold && -diff < threshold {
+			continue
+		}
+		v := float64(src.Pix[i]) + amount*float64(diff)
+		src.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	return src
+}
+
+// createAdjustControls creates the output adjustment settings
+func createAdjustControls() *fyne.Container {
+	slider := func(min, max float64, value *float64) *widget.Slider {
+		s := widget.NewSlider(min, max)
+		s.Value = *value
+		s.OnChanged = func(v float64) {
+			*value = v
+			updatePreview()
+		}
+		return s
+	}
+
+	toneSelect := widget.NewSelect([]string{"none", "grayscale", "sepia"}, func(value string) {
+		appData.Adjust.Tone = value
+		updatePreview()
+	})
+	toneSelect.SetSelected(appData.Adjust.Tone)
+
+	radiusEntry := widget.NewEntry()
+	radiusEntry.SetText(strconv.FormatFloat(appData.Adjust.SharpenRadius, 'f', -1, 64))
+	radiusEntry.OnChanged = func(text string) {
+		if radius, err := strconv.ParseFloat(text, 64); err == nil && radius > 0 && radius <= 20 {
+			appData.Adjust.SharpenRadius = radius
+			updatePreview()
+		}
+	}
+
+	thresholdEntry := widget.NewEntry()
+	thresholdEntry.SetText(strconv.Itoa(appData.Adjust.SharpenThreshold))
+	thresholdEntry.OnChanged = func(text string) {
+		if threshold, err := strconv.Atoi(text); err == nil && threshold >= 0 && threshold <= 255 {
+			appData.Adjust.SharpenThreshold = threshold
+			updatePreview()
+		}
+	}
+
+	return container.NewVBox(
+		widget.NewLabel("Brightness:"),
+		slider(-100, 100, &appData.Adjust.Brightness),
+		widget.NewLabel("Contrast:"),
+		slider(-100, 100, &appData.Adjust.Contrast),
+		widget.NewLabel("Saturation:"),
+		slider(-100, 100, &appData.Adjust.Saturation),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Tone:"),
+			toneSelect,
+		),
+		widget.NewLabel("Sharpen Amount (%):"),
+		slider(0, 300, &appData.Adjust.SharpenAmount),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Sharpen Radius (px):"),
+			radiusEntry,
+			widget.NewLabel("Sharpen Threshold:"),
+			thresholdEntry,
+		),
+	)
+}
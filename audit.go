@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	// auditLogName is the append-only export audit log, one JSON entry per line
+	auditLogName = "audit_log.jsonl"
+
+	// auditHeadName records the entry count and the hash of the last line,
+	// so entries removed from the end are noticed too
+	auditHeadName = "audit_head.json"
+
+	// auditKeyName is the Ed25519 key the head and chain breaks are signed
+	// with. It is kept next to the log, so a valid signature shows the head
+	// was written by this app, not that nobody who can read the folder
+	// rewrote the log and signed it again.
+	auditKeyName = "audit_key.pem"
+
+	// auditResetHint tells the user how to get exports going again
+	auditResetHint = `check the log with "watermark-app audit" and start a new chain with "audit -reset"`
+)
+
+// AuditEntry records the files written for one exported image. Prev is the
+// hash of the previous line exactly as stored, which chains the entries
+// together.
+type AuditEntry struct {
+	Seq        int
+	Time       time.Time
+	User       string
+	Input      string
+	InputHash  string
+	Output     string
+	OutputHash string
+	Sidecars   map[string]string // path of each sidecar file to its hash
+	Template   string
+	Break      *AuditBreak // set on the entry that starts a new chain
+	Prev       string
+}
+
+// AuditBreak records why a new chain was started on a log that could no
+// longer be extended, and the head the old chain had
+type AuditBreak struct {
+	Reason    string
+	OldCount  int
+	OldHash   string
+	Key       string // fingerprint of the audit key signing the new chain
+	Signature []byte // Ed25519 signature of the entry without it
+}
+
+// auditHead is the state of the end of the chain
+type auditHead struct {
+	Count     int
+	Hash      string
+	Size      int64  // length of the log in bytes
+	Signature []byte // Ed25519 signature of the fields above
+}
+
+// message returns the bytes the head signature covers
+func (h auditHead) message() []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n%d", auditHeadName, h.Count, h.Hash, h.Size))
+}
+
+// breakMessage returns the bytes a break signature covers
+func (e AuditEntry) breakMessage() []byte {
+	b := *e.Break
+	b.Signature = nil
+	e.Break = &b
+	data, _ := json.Marshal(e)
+	return data
+}
+
+// auditPath returns the path of a file of the audit log
+func auditPath(name string) string {
+	return filepath.Join(appDataDir(), name)
+}
+
+// readAuditHead reads the head, returning an empty one if there is none
+func readAuditHead() (auditHead, error) {
+	var head auditHead
+	data, err := os.ReadFile(auditPath(auditHeadName))
+	if errors.Is(err, os.ErrNotExist) {
+		return head, nil
+	}
+	if err != nil {
+		return head, err
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, fmt.Errorf("%s: %v", auditHeadName, err)
+	}
+	return head, nil
+}
+
+// currentUser names the user running the export
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// hashFile returns the SHA-256 of a file in the form stored in manifests
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+// followsHead reports whether tail is exactly one entry that continues the
+// chain at head. That is left behind when an export stops between writing
+// its entry and the head.
+func followsHead(tail []byte, head auditHead) bool {
+	line, ok := bytes.CutSuffix(tail, []byte("\n"))
+	if !ok || bytes.Contains(line, []byte("\n")) {
+		return false
+	}
+	var entry AuditEntry
+	return json.Unmarshal(line, &entry) == nil && entry.Seq == head.Count+1 && entry.Prev == head.Hash
+}
+
+// auditChainEnd returns the audit key and the end of the chain, picking up
+// after an export that stopped half way. It fails when the log no longer
+// matches its head, which takes a new chain to fix.
+func auditChainEnd() (ed25519.PrivateKey, auditHead, error) {
+	head, err := readAuditHead()
+	if err != nil {
+		return nil, head, fmt.Errorf("%v; %s", err, auditResetHint)
+	}
+	var size int64
+	if info, err := os.Stat(auditPath(auditLogName)); err == nil {
+		size = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, head, err
+	}
+
+	// A key is only created with a new log, a missing one isn't replaced
+	fresh := head.Count == 0 && size == 0
+	key, err := loadKey(auditPath(auditKeyName), fresh)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, head, fmt.Errorf("%s is missing; %s", auditKeyName, auditResetHint)
+	}
+	if err != nil {
+		return nil, head, err
+	}
+	switch {
+	case fresh:
+	case head.Signature == nil:
+		return nil, head, fmt.Errorf("%s is missing; %s", auditHeadName, auditResetHint)
+	case !ed25519.Verify(key.Public().(ed25519.PublicKey), head.message(), head.Signature):
+		return nil, head, fmt.Errorf("%s isn't signed by the audit key; %s", auditHeadName, auditResetHint)
+	}
+
+	if size == head.Size {
+		return key, head, nil
+	}
+	if size < head.Size {
+		return nil, head, fmt.Errorf("the log is shorter than its head; %s", auditResetHint)
+	}
+	data, err := os.ReadFile(auditPath(auditLogName))
+	if err != nil {
+		return nil, head, err
+	}
+	tail := data[min(len(data), int(head.Size)):]
+	switch {
+	case followsHead(tail, head):
+		return key, auditHead{Count: head.Count + 1, Hash: hashBytes(bytes.TrimSuffix(tail, []byte("\n"))), Size: size}, nil
+	case !bytes.Contains(tail, []byte("\n")):
+		// A line was cut off while being written
+		return key, head, os.Truncate(auditPath(auditLogName), head.Size)
+	}
+	return nil, head, fmt.Errorf("the log doesn't match its head; %s", auditResetHint)
+}
+
+// checkAuditLog makes sure an entry can be added before an export writes
+// its files
+func checkAuditLog() error {
+	_, _, err := auditChainEnd()
+	return err
+}
+
+// writeAuditEntry adds an entry after head and moves the head past it
+func writeAuditEntry(key ed25519.PrivateKey, head auditHead, entry AuditEntry) error {
+	entry.Seq = head.Count + 1
+	entry.Prev = head.Hash
+	if entry.Break != nil {
+		entry.Break.Signature = ed25519.Sign(key, entry.breakMessage())
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(appDataDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditPath(auditLogName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	head = auditHead{Count: head.Count + 1, Hash: hashBytes(line), Size: head.Size + int64(len(line)) + 1}
+	head.Signature = ed25519.Sign(key, head.message())
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	// Replace the head in one step so a crash can't leave half a file
+	tmp := auditPath(auditHeadName + ".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, auditPath(auditHeadName))
+}
+
+// appendAuditEntry records the files an export just wrote
+func appendAuditEntry(ctx *ImageContext) error {
+	inputHash, err := hashFile(ctx.Path)
+	if err != nil {
+		return err
+	}
+	outputHash, err := hashFile(ctx.OutputPath)
+	if err != nil {
+		return err
+	}
+	var sidecars map[string]string
+	for _, path := range ctx.Sidecars {
+		hash, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		if sidecars == nil {
+			sidecars = make(map[string]string)
+		}
+		abs, _ := filepath.Abs(path)
+		sidecars[abs] = hash
+	}
+
+	key, head, err := auditChainEnd()
+	if err != nil {
+		return err
+	}
+	input, _ := filepath.Abs(ctx.Path)
+	output, _ := filepath.Abs(ctx.OutputPath)
+	return writeAuditEntry(key, head, AuditEntry{
+		Time:       time.Now().UTC(),
+		User:       currentUser(),
+		Input:      input,
+		InputHash:  inputHash,
+		Output:     output,
+		OutputHash: outputHash,
+		Sidecars:   sidecars,
+		Template:   appData.TemplateName,
+	})
+}
+
+// startNewAuditChain continues the log with a signed break entry that
+// records the old head, creating the audit key if it is missing. Lines
+// already in the log are kept.
+func startNewAuditChain(reason string) error {
+	old, _ := readAuditHead()
+	key, err := loadKey(auditPath(auditKeyName), true)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(auditPath(auditLogName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// End a cut off line so the break starts on a line of its own
+		f, err := os.OpenFile(auditPath(auditLogName), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte("\n"))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	head := auditHead{Size: int64(len(data))}
+	if len(data) > 0 {
+		lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+		head.Count = len(lines)
+		head.Hash = hashBytes(lines[len(lines)-1])
+	}
+	return writeAuditEntry(key, head, AuditEntry{
+		Time: time.Now().UTC(),
+		User: currentUser(),
+		Break: &AuditBreak{
+			Reason:   reason,
+			OldCount: old.Count,
+			OldHash:  old.Hash,
+			Key:      keyFingerprint(key.Public().(ed25519.PublicKey)),
+		},
+	})
+}
+
+// auditReport is the outcome of checking the audit log
+type auditReport struct {
+	Entries  []AuditEntry
+	Problems []string
+	Notes    []string
+}
+
+// String formats the result of the check
+func (r auditReport) String() string {
+	if len(r.Problems) == 0 {
+		return strings.Join(append([]string{fmt.Sprintf("Audit log intact: %d entries", len(r.Entries))}, r.Notes...), "\n")
+	}
+	return fmt.Sprintf("Audit log TAMPERED (%d entries read):\n%s",
+		len(r.Entries), strings.Join(append(r.Problems, r.Notes...), "\n"))
+}
+
+// verifyAuditLog reads the log and checks the chain against the signed head.
+// Problems found before a chain break are reported as notes, with the break.
+func verifyAuditLog() (auditReport, error) {
+	var report auditReport
+	problem := func(format string, args ...interface{}) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	head, err := readAuditHead()
+	if err != nil {
+		problem("%v", err)
+	}
+
+	data, err := os.ReadFile(auditPath(auditLogName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return report, err
+	}
+
+	var public ed25519.PublicKey
+	key, keyErr := loadKey(auditPath(auditKeyName), false)
+	if keyErr == nil {
+		public = key.Public().(ed25519.PublicKey)
+	}
+
+	// hashes[i] is the hash of line i, hashes[0] the start of the chain
+	hashes := []string{""}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		count := len(hashes)
+		line := scanner.Bytes()
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			problem("line %d is unreadable: %v", count, err)
+		} else {
+			if entry.Break != nil {
+				for _, p := range report.Problems {
+					report.Notes = append(report.Notes, fmt.Sprintf("before entry %d: %s", count, p))
+				}
+				report.Problems = nil
+				report.Notes = append(report.Notes, fmt.Sprintf("entry %d started a new chain: %s (the old head had %d entries)",
+					count, entry.Break.Reason, entry.Break.OldCount))
+				switch {
+				case public == nil || entry.Break.Key != keyFingerprint(public):
+					report.Notes = append(report.Notes, fmt.Sprintf("entry %d was signed by an earlier audit key", count))
+				case !ed25519.Verify(public, entry.breakMessage(), entry.Break.Signature):
+					problem("entry %d is a chain break the audit key didn't sign", count)
+				}
+			}
+			if entry.Seq != count {
+				problem("line %d has entry number %d: entries were deleted or reordered", count, entry.Seq)
+			}
+			if entry.Prev != hashes[count-1] {
+				problem("line %d doesn't follow line %d: an entry was edited or deleted", count, count-1)
+			}
+			report.Entries = append(report.Entries, entry)
+		}
+		hashes = append(hashes, hashBytes(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+
+	if head.Count > 0 || len(data) > 0 {
+		switch {
+		case keyErr != nil:
+			problem("the head signature can't be checked: %v", keyErr)
+		case !ed25519.Verify(public, head.message(), head.Signature):
+			problem("%s isn't signed by the audit key: it was edited or replaced, or the key changed", auditHeadName)
+		}
+	}
+	count := len(hashes) - 1
+	switch {
+	case count == head.Count+1 && hashes[head.Count] == head.Hash:
+		// The entry was written but the export stopped before the head
+		report.Notes = append(report.Notes, fmt.Sprintf("head not updated: entry %d was written by an export that didn't finish", count))
+	case head.Count != count:
+		problem("the log has %d entries but the head expects %d: entries were deleted or added", count, head.Count)
+	case head.Hash != hashes[count]:
+		problem("the last entry was edited")
+	}
+	return report, nil
+}
+
+// auditCommand implements "audit [-list] [-reset]"
+func auditCommand(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	list := flags.Bool("list", false, "print every entry")
+	reset := flags.Bool("reset", false, "start a new chain when the log can't be extended")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: watermark-app audit [-list] [-reset]")
+		fmt.Fprintln(flags.Output(), "Checks the export audit log for deleted or edited entries.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *reset {
+		if err := startNewAuditChain("started with audit -reset"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	report, err := verifyAuditLog()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *list {
+		for _, entry := range report.Entries {
+			fmt.Println(entry.summary())
+		}
+	}
+	fmt.Printf("%s\n%s\n", auditPath(auditLogName), report)
+	if len(report.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// summary formats an entry on one line
+func (e AuditEntry) summary() string {
+	if e.Break != nil {
+		return fmt.Sprintf("#%d  %s  %s  NEW CHAIN: %s",
+			e.Seq, e.Time.Local().Format("2006-01-02 15:04:05"), e.User, e.Break.Reason)
+	}
+	template := e.Template
+	if template == "" {
+		template = "-"
+	}
+	return fmt.Sprintf("#%d  %s  %s  %s -> %s  template %s",
+		e.Seq, e.Time.Local().Format("2006-01-02 15:04:05"), e.User,
+		filepath.Base(e.Input), filepath.Base(e.Output), template)
+}
+
+// ShowAuditLog shows the audit log, newest first, with the result of the check
+func (ec *EnhancedControls) ShowAuditLog() {
+	report, err := verifyAuditLog()
+	if err != nil {
+		dialog.ShowError(err, ec.window)
+		return
+	}
+
+	entries := report.Entries
+	list := widget.NewList(
+		func() int { return len(entries) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			item.(*widget.Label).SetText(entries[len(entries)-1-id].summary())
+		},
+	)
+
+	details := widget.NewLabel("Select an entry to see its paths and hashes")
+	details.Wrapping = fyne.TextWrapBreak
+	list.OnSelected = func(id widget.ListItemID) {
+		e := entries[len(entries)-1-id]
+		if e.Break != nil {
+			details.SetText(fmt.Sprintf("New chain: %s\nOld head: %d entries, %s\nAudit key: %s",
+				e.Break.Reason, e.Break.OldCount, e.Break.OldHash, e.Break.Key))
+			return
+		}
+		text := fmt.Sprintf("Input: %s\n  %s\nOutput: %s\n  %s",
+			e.Input, e.InputHash, e.Output, e.OutputHash)
+		for path, hash := range e.Sidecars {
+			text += fmt.Sprintf("\nSidecar: %s\n  %s", path, hash)
+		}
+		details.SetText(text)
+	}
+
+	status := widget.NewLabel(report.String())
+	var d dialog.Dialog
+	top := fyne.CanvasObject(status)
+	if checkAuditLog() != nil {
+		// Exports stop until the log can be extended again
+		resetBtn := widget.NewButton("Start New Chain...", func() {
+			dialog.ShowConfirm("Start New Chain",
+				"Exports are blocked because the log no longer matches its head.\n"+
+					"A signed entry recording the break will be added. Continue?", func(ok bool) {
+					if !ok {
+						return
+					}
+					if err := startNewAuditChain("started from the audit log viewer"); err != nil {
+						dialog.ShowError(err, ec.window)
+						return
+					}
+					d.Hide()
+					ec.ShowAuditLog()
+				}, ec.window)
+		})
+		top = container.NewVBox(status, resetBtn)
+	}
+	content := container.NewBorder(top, details, nil, nil, list)
+	d = dialog.NewCustom("Export Audit Log", "Close", content, ec.window)
+	d.Resize(fyne.NewSize(860, 560))
+	d.Show()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// BarcodeConfig holds the barcode watermark settings
+type BarcodeConfig struct {
+	Symbology    string // "Code 128" or "EAN-13"
+	Value        string
+	FromFilename bool   // take the value from each image's file name
+	Pattern      string // optional regexp; the first group (or whole match) is used
+	ShowText     bool
+	BarHeight    int // in pixels
+	ModuleWidth  int // in pixels
+	QuietZone    int // in modules
+}
+
+// barcodeValue returns the value to encode for the given image
+func barcodeValue(ctx *ImageContext) (string, error) {
+	cfg := appData.Watermark.Barcode
+	if !cfg.FromFilename || ctx == nil {
+		return expandTokens(cfg.Value, ctx), nil
+	}
+
+	name := strings.TrimSuffix(filepath.Base(ctx.Path), filepath.Ext(ctx.Path))
+	if cfg.Pattern == "" {
+		return name, nil
+	}
+
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return "", err
+	}
+	match := re.FindStringSubmatch(name)
+	if match == nil {
+		return "", errors.New("file name does not match the barcode pattern")
+	}
+	if len(match) > 1 {
+		return match[1], nil
+	}
+	return match[0], nil
+}
+
+// encodeBarcode encodes value with the configured symbology
+func encodeBarcode(value string) (barcode.Barcode, error) {
+	if appData.Watermark.Barcode.Symbology == "EAN-13" {
+		if len(value) != 12 && len(value) != 13 {
+			return nil, errors.New("EAN-13 needs 12 or 13 digits")
+		}
+		return ean.Encode(value)
+	}
+	return code128.Encode(value)
+}
+
+// renderBarcodeWatermark draws the barcode for the image as a watermark layer
+func renderBarcodeWatermark(ctx *ImageContext) image.Image {
+	cfg := appData.Watermark.Barcode
+
+	value, err := barcodeValue(ctx)
+	if err == nil && value == "" {
+		return nil
+	}
+	var code barcode.Barcode
+	if err == nil {
+		code, err = encodeBarcode(value)
+	}
+	if err != nil {
+		ctx.Logf("barcode not generated: %v", err)
+		return nil
+	}
+
+	moduleWidth := max(1, cfg.ModuleWidth)
+	barHeight := max(1, cfg.BarHeight)
+	quiet := max(0, cfg.QuietZone) * moduleWidth
+	modules := code.Bounds().Dx()
+	barsWidth := modules * moduleWidth
+
+	// Human-readable text is drawn with the built-in face and scaled up
+	// in whole steps so it stays crisp
+	content := code.Content()
+	textScale := 0
+	textHeight := 0
+	if cfg.ShowText {
+		textScale = max(1, min(barHeight/26, barsWidth/(len(content)*7+1)))
+		textHeight = 15 * textScale
+	}
+
+	width := barsWidth + 2*quiet
+	height := barHeight + textHeight + 2*moduleWidth
+	layer := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(layer, layer.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	black := image.NewUniform(color.Black)
+	for x := 0; x < modules; x++ {
+		r, _, _, _ := code.At(x, 0).RGBA()
+		if r != 0 {
+			continue
+		}
+		px := quiet + x*moduleWidth
+		draw.Draw(layer, image.Rect(px, moduleWidth, px+moduleWidth, moduleWidth+barHeight), black, image.Point{}, draw.Src)
+	}
+
+	if cfg.ShowText {
+		textImg := image.NewNRGBA(image.Rect(0, 0, len(content)*7, 15))
+		drawer := &font.Drawer{
+			Dst:  textImg,
+			Src:  black,
+			Face: basicfont.Face7x13,
+			Dot:  fixed.P(0, 12),
+		}
+		drawer.DrawString(content)
+		scaled := imaging.Resize(textImg, textImg.Bounds().Dx()*textScale, textHeight, imaging.NearestNeighbor)
+		x := (width - scaled.Bounds().Dx()) / 2
+		y := moduleWidth + barHeight
+		draw.Draw(layer, image.Rect(x, y, x+scaled.Bounds().Dx(), y+textHeight), scaled, image.Point{}, draw.Over)
+	}
+
+	ctx.Logf("barcode %s %q", code.Metadata().CodeKind, content)
+	return layer
+}
+
+// createBarcodeControls creates the barcode watermark settings
+func createBarcodeControls() *fyne.Container {
+	symbologySelect := widget.NewSelect([]string{"Code 128", "EAN-13"}, func(value string) {
+		appData.Watermark.Barcode.Symbology = value
+		updatePreview()
+	})
+	symbologySelect.SetSelected(appData.Watermark.Barcode.Symbology)
+
+	valueEntry := widget.NewEntry()
+	valueEntry.SetPlaceHolder("SKU or EAN digits, or a {column} token")
+	valueEntry.SetText(appData.Watermark.Barcode.Value)
+	valueEntry.OnChanged = func(text string) {
+		appData.Watermark.Barcode.Value = text
+		updatePreview()
+	}
+
+	patternEntry := widget.NewEntry()
+	patternEntry.SetPlaceHolder(`Pattern, e.g. ^SKU-(\d+)`)
+	patternEntry.SetText(appData.Watermark.Barcode.Pattern)
+	patternEntry.OnChanged = func(text string) {
+		appData.Watermark.Barcode.Pattern = text
+		updatePreview()
+	}
+
+	fromFilenameCheck := widget.NewCheck("Value from file name", func(checked bool) {
+		appData.Watermark.Barcode.FromFilename = checked
+		updatePreview()
+	})
+	fromFilenameCheck.SetChecked(appData.Watermark.Barcode.FromFilename)
+
+	showTextCheck := widget.NewCheck("Show human-readable text", func(checked bool) {
+		appData.Watermark.Barcode.ShowText = checked
+		updatePreview()
+	})
+	showTextCheck.SetChecked(appData.Watermark.Barcode.ShowText)
+
+	barHeightEntry := widget.NewEntry()
+	barHeightEntry.SetText(strconv.Itoa(appData.Watermark.Barcode.BarHeight))
+	barHeightEntry.OnChanged = func(text string) {
+		if height, err := strconv.Atoi(text); err == nil && height > 0 && height <= 2000 {
+			appData.Watermark.Barcode.BarHeight = height
+			updatePreview()
+		}
+	}
+
+	moduleWidthEntry := widget.NewEntry()
+	moduleWidthEntry.SetText(strconv.Itoa(appData.Watermark.Barcode.ModuleWidth))
+	moduleWidthEntry.OnChanged = func(text string) {
+		if width, err := strconv.Atoi(text); err == nil && width > 0 && width <= 50 {
+			appData.Watermark.Barcode.ModuleWidth = width
+			updatePreview()
+		}
+	}
+
+	quietEntry := widget.NewEntry()
+	quietEntry.SetText(strconv.Itoa(appData.Watermark.Barcode.QuietZone))
+	quietEntry.OnChanged = func(text string) {
+		if quiet, err := strconv.Atoi(text); err == nil && quiet >= 0 && quiet <= 50 {
+			appData.Watermark.Barcode.QuietZone = quiet
+			updatePreview()
+		}
+	}
+
+	return container.NewVBox(
+		widget.NewLabel("Barcode Watermark:"),
+		symbologySelect,
+		valueEntry,
+		fromFilenameCheck,
+		patternEntry,
+		showTextCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Bar Height (px):"),
+			barHeightEntry,
+			widget.NewLabel("Module Width (px):"),
+			moduleWidthEntry,
+			widget.NewLabel("Quiet Zone (modules):"),
+			quietEntry,
+		),
+	)
+}
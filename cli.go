@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// commands are the subcommands available from the command line. Each
+// returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"audit":   auditCommand,
+	"inspect": inspectCommand,
+	"restore": restoreCommand,
+	"tamper":  tamperCommand,
+	"trace":   traceCommand,
+	"verify":  verifyCommand,
+}
+
+// runCommand runs the subcommand named by args[0] and reports whether there
+// was one. Without arguments the GUI starts.
+func runCommand(args []string) (int, bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return 0, false
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, available commands: %v\n", args[0], names)
+		return 2, true
+	}
+	return command(args[1:]), true
+}
@@ -171,6 +171,7 @@ func (cp *ColorPicker) ShowColorPicker(currentColor color.RGBA, onColorSelected
 type EnhancedControls struct {
 	window         fyne.Window
 	templateMgr    *TemplateManager
+	presetMgr      *PresetManager
 	colorPicker    *ColorPicker
 	rotationSlider *widget.Slider
 	shadowCheck    *widget.Check
@@ -182,6 +183,7 @@ func NewEnhancedControls(window fyne.Window) *EnhancedControls {
 	return &EnhancedControls{
 		window:      window,
 		templateMgr: NewTemplateManager(window),
+		presetMgr:   NewPresetManager(window),
 		colorPicker: NewColorPicker(window),
 	}
 }
@@ -226,6 +228,110 @@ func (ec *EnhancedControls) CreateAdvancedControls() *fyne.Container {
 		}
 	}
 
+	// Anti-removal jitter
+	jitterCheck := widget.NewCheck("Enable Per-Image Jitter", func(checked bool) {
+		appData.Watermark.Jitter.Enabled = checked
+		updatePreview()
+	})
+	jitterCheck.SetChecked(appData.Watermark.Jitter.Enabled)
+
+	jitterSeedEntry := widget.NewEntry()
+	jitterSeedEntry.SetPlaceHolder("Seed")
+	jitterSeedEntry.SetText(appData.Watermark.Jitter.Seed)
+	jitterSeedEntry.OnChanged = func(text string) {
+		appData.Watermark.Jitter.Seed = text
+		updatePreview()
+	}
+
+	jitterOffsetEntry := widget.NewEntry()
+	jitterOffsetEntry.SetText(strconv.Itoa(appData.Watermark.Jitter.Offset))
+	jitterOffsetEntry.OnChanged = func(text string) {
+		if offset, err := strconv.Atoi(text); err == nil && offset >= 0 {
+			appData.Watermark.Jitter.Offset = offset
+			updatePreview()
+		}
+	}
+
+	jitterRotationEntry := widget.NewEntry()
+	jitterRotationEntry.SetText(strconv.FormatFloat(appData.Watermark.Jitter.Rotation, 'f', -1, 64))
+	jitterRotationEntry.OnChanged = func(text string) {
+		if rotation, err := strconv.ParseFloat(text, 64); err == nil && rotation >= 0 {
+			appData.Watermark.Jitter.Rotation = rotation
+			updatePreview()
+		}
+	}
+
+	jitterScaleEntry := widget.NewEntry()
+	jitterScaleEntry.SetText(strconv.Itoa(appData.Watermark.Jitter.Scale))
+	jitterScaleEntry.OnChanged = func(text string) {
+		if scale, err := strconv.Atoi(text); err == nil && scale >= 0 && scale < 100 {
+			appData.Watermark.Jitter.Scale = scale
+			updatePreview()
+		}
+	}
+
+	jitterOpacityEntry := widget.NewEntry()
+	jitterOpacityEntry.SetText(strconv.Itoa(appData.Watermark.Jitter.Opacity))
+	jitterOpacityEntry.OnChanged = func(text string) {
+		if opacity, err := strconv.Atoi(text); err == nil && opacity >= 0 && opacity <= 100 {
+			appData.Watermark.Jitter.Opacity = opacity
+			updatePreview()
+		}
+	}
+
+	// Geometric warp
+	warpCheck := widget.NewCheck("Enable Wave Warp", func(checked bool) {
+		appData.Watermark.Warp.Enabled = checked
+		updatePreview()
+	})
+	warpCheck.SetChecked(appData.Watermark.Warp.Enabled)
+
+	warpSeedEntry := widget.NewEntry()
+	warpSeedEntry.SetPlaceHolder("Seed")
+	warpSeedEntry.SetText(appData.Watermark.Warp.Seed)
+	warpSeedEntry.OnChanged = func(text string) {
+		appData.Watermark.Warp.Seed = text
+		updatePreview()
+	}
+
+	warpAmplitudeEntry := widget.NewEntry()
+	warpAmplitudeEntry.SetText(strconv.FormatFloat(appData.Watermark.Warp.Amplitude, 'f', -1, 64))
+	warpAmplitudeEntry.OnChanged = func(text string) {
+		if amplitude, err := strconv.ParseFloat(text, 64); err == nil && amplitude >= 0 && amplitude <= 100 {
+			appData.Watermark.Warp.Amplitude = amplitude
+			updatePreview()
+		}
+	}
+
+	warpFrequencyEntry := widget.NewEntry()
+	warpFrequencyEntry.SetText(strconv.FormatFloat(appData.Watermark.Warp.Frequency, 'f', -1, 64))
+	warpFrequencyEntry.OnChanged = func(text string) {
+		if frequency, err := strconv.ParseFloat(text, 64); err == nil && frequency > 0 && frequency <= 50 {
+			appData.Watermark.Warp.Frequency = frequency
+			updatePreview()
+		}
+	}
+
+	// Knockout fill
+	knockoutCheck := widget.NewCheck("Knockout Fill (text and logo)", func(checked bool) {
+		appData.Watermark.Knockout.Enabled = checked
+		updatePreview()
+	})
+	knockoutCheck.SetChecked(appData.Watermark.Knockout.Enabled)
+
+	knockoutEffect := widget.NewSelect([]string{"blur", "desaturate", "brighten"}, func(value string) {
+		appData.Watermark.Knockout.Effect = value
+		updatePreview()
+	})
+	knockoutEffect.SetSelected(appData.Watermark.Knockout.Effect)
+
+	knockoutStrength := widget.NewSlider(0, 100)
+	knockoutStrength.Value = float64(appData.Watermark.Knockout.Strength)
+	knockoutStrength.OnChanged = func(value float64) {
+		appData.Watermark.Knockout.Strength = int(value)
+		updatePreview()
+	}
+
 	// Template management buttons
 	saveTemplateBtn := widget.NewButton("Save Template", func() {
 		ec.templateMgr.SaveTemplate()
@@ -270,6 +376,42 @@ func (ec *EnhancedControls) CreateAdvancedControls() *fyne.Container {
 
 		widget.NewSeparator(),
 
+		widget.NewLabel("Anti-Removal Jitter:"),
+		jitterCheck,
+		jitterSeedEntry,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Max Offset (px):"),
+			jitterOffsetEntry,
+			widget.NewLabel("Max Rotation (°):"),
+			jitterRotationEntry,
+			widget.NewLabel("Max Scale (%):"),
+			jitterScaleEntry,
+			widget.NewLabel("Max Opacity (%):"),
+			jitterOpacityEntry,
+		),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Knockout:"),
+		knockoutCheck,
+		knockoutEffect,
+		widget.NewLabel("Knockout Strength:"),
+		knockoutStrength,
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Geometric Warp:"),
+		warpCheck,
+		warpSeedEntry,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Amplitude (px):"),
+			warpAmplitudeEntry,
+			widget.NewLabel("Frequency (per 100px):"),
+			warpFrequencyEntry,
+		),
+
+		widget.NewSeparator(),
+
 		widget.NewLabel("Template Management:"),
 		container.NewGridWithColumns(2,
 			saveTemplateBtn,
@@ -292,12 +434,14 @@ func (ec *EnhancedControls) CreatePositionControls() *fyne.Container {
 		"top-left", "top-center", "top-right",
 		"center-left", "center", "center-right",
 		"bottom-left", "bottom-center", "bottom-right",
+		"manual", "auto",
 	}
 
 	labels := []string{
 		"Top-Left", "Top-Center", "Top-Right",
 		"Center-Left", "Center", "Center-Right",
 		"Bottom-Left", "Bottom-Center", "Bottom-Right",
+		"Manual", "Auto (Smart)",
 	}
 
 	for i, pos := range positions {
@@ -329,6 +473,37 @@ func (ec *EnhancedControls) CreatePositionControls() *fyne.Container {
 		}
 	}
 
+	// Smart placement candidates
+	autoManualCheck := widget.NewCheck("Include manual position", func(checked bool) {
+		appData.Watermark.AutoUseManual = checked
+		updatePreview()
+	})
+	autoManualCheck.SetChecked(appData.Watermark.AutoUseManual)
+
+	// Exclusion zones
+	zoneLabel := widget.NewLabel("")
+	updateZoneLabel := func() {
+		zoneLabel.SetText(strconv.Itoa(len(appData.Watermark.ExclusionZones)) + " zone(s)")
+	}
+	updateZoneLabel()
+
+	drawZoneBtn := widget.NewButton("Draw Zone on Preview", func() {
+		if globalPreviewWidget == nil {
+			return
+		}
+		globalPreviewWidget.SelectRegion(func(zone PercentRect) {
+			appData.Watermark.ExclusionZones = append(appData.Watermark.ExclusionZones, zone)
+			updateZoneLabel()
+			updatePreview()
+		})
+	})
+
+	clearZonesBtn := widget.NewButton("Clear Zones", func() {
+		appData.Watermark.ExclusionZones = nil
+		updateZoneLabel()
+		updatePreview()
+	})
+
 	// Position controls layout
 	positionControls := container.NewVBox(
 		widget.NewLabel("Position Settings"),
@@ -346,6 +521,19 @@ func (ec *EnhancedControls) CreatePositionControls() *fyne.Container {
 			widget.NewLabel("Y:"),
 			yEntry,
 		),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Smart Placement (Auto):"),
+		widget.NewLabel("Picks the calmest of the nine grid cells per image"),
+		autoManualCheck,
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Exclusion Zones:"),
+		widget.NewLabel("The watermark is moved or skipped to stay out of these areas"),
+		container.NewHBox(drawZoneBtn, clearZonesBtn),
+		zoneLabel,
 	)
 
 	return positionControls
@@ -379,13 +567,6 @@ func (ec *EnhancedControls) CreateOutputControls() *fyne.Container {
 		appData.Suffix = text
 	}
 
-	// Size scaling controls
-	scaleEntry := widget.NewEntry()
-	scaleEntry.SetText("100")
-	scaleEntry.OnChanged = func(text string) {
-		// TODO: Implement scaling
-	}
-
 	// Output controls layout
 	outputControls := container.NewVBox(
 		widget.NewLabel("Output Settings"),
@@ -407,8 +588,49 @@ func (ec *EnhancedControls) CreateOutputControls() *fyne.Container {
 			suffixEntry,
 		),
 
-		widget.NewLabel("Scale (%):"),
-		scaleEntry,
+		widget.NewLabel("Resize (before watermark):"),
+		createResizeControls(),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Crop:"),
+		createCropControls(),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Adjustments (after resize):"),
+		createAdjustControls(),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Metadata Privacy:"),
+		createPrivacyControls(),
+		createThumbnailControls(),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Copyright Metadata:"),
+		createRightsControls(),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Manifest (one output per row):"),
+		createManifestControls(ec.window),
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Output Presets:"),
+		container.NewGridWithColumns(3,
+			widget.NewButton("Save Preset", func() {
+				ec.presetMgr.SavePreset()
+			}),
+			widget.NewButton("Load Preset", func() {
+				ec.presetMgr.LoadPreset()
+			}),
+			widget.NewButton("Delete Preset", func() {
+				ec.presetMgr.DeletePreset()
+			}),
+		),
 	)
 
 	return outputControls
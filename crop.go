@@ -0,0 +1,220 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// CropConfig crops every image to an aspect ratio before watermarking
+type CropConfig struct {
+	Enabled bool
+	Ratio   string // "1:1", "4:5", "16:9" or "custom"
+	CustomW float64
+	CustomH float64
+	Anchor  string // a grid position, "manual" or "smart"
+}
+
+// cropRatios maps the presets to width/height ratios
+var cropRatios = map[string]float64{
+	"1:1":  1,
+	"4:5":  4.0 / 5.0,
+	"16:9": 16.0 / 9.0,
+}
+
+// cropAspect returns the configured width/height ratio
+func cropAspect() float64 {
+	cfg := appData.Crop
+	if ratio, ok := cropRatios[cfg.Ratio]; ok {
+		return ratio
+	}
+	if cfg.CustomW > 0 && cfg.CustomH > 0 {
+		return cfg.CustomW / cfg.CustomH
+	}
+	return 1
+}
+
+// cropRect returns the largest rectangle of the configured ratio, placed by
+// the anchor, the manually placed crop box or the most detailed region
+func cropRect(img image.Image, ctx *ImageContext) image.Rectangle {
+	bounds := img.Bounds()
+	ratio := cropAspect()
+	w, h := bounds.Dx(), bounds.Dy()
+	if float64(w)/float64(h) > ratio {
+		w = max(1, int(math.Round(float64(h)*ratio)))
+	} else {
+		h = max(1, int(math.Round(float64(w)/ratio)))
+	}
+	freeX := bounds.Dx() - w
+	freeY := bounds.Dy() - h
+
+	var x, y int
+	switch anchor := appData.Crop.Anchor; anchor {
+	case "smart":
+		x, y = smartCropOffset(img, w, h)
+	case "manual":
+		// Crop to the box drawn on the preview, narrowed to the ratio
+		// around its center
+		x, y = freeX/2, freeY/2
+		if ctx != nil {
+			if box, ok := appData.CropBoxes[ctx.Path]; ok {
+				drawn := box.Rect(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+				if !drawn.Empty() {
+					bw, bh := float64(drawn.Dx()), float64(drawn.Dy())
+					if bw/bh > ratio {
+						bw = bh * ratio
+					} else {
+						bh = bw / ratio
+					}
+					w, h = max(1, int(math.Round(bw))), max(1, int(math.Round(bh)))
+					center := drawn.Min.Add(drawn.Size().Div(2))
+					x = max(0, min(bounds.Dx()-w, center.X-w/2))
+					y = max(0, min(bounds.Dy()-h, center.Y-h/2))
+				}
+			}
+		}
+	default:
+		x, y = freeX/2, freeY/2
+		switch anchor {
+		case "top-left", "center-left", "bottom-left":
+			x = 0
+		case "top-right", "center-right", "bottom-right":
+			x = freeX
+		}
+		switch anchor {
+		case "top-left", "top-center", "top-right":
+			y = 0
+		case "bottom-left", "bottom-center", "bottom-right":
+			y = freeY
+		}
+	}
+
+	return image.Rect(x, y, x+w, y+h).Add(bounds.Min)
+}
+
+// smartCropOffset slides the crop window along the free axis and keeps the
+// position with the most detail
+func smartCropOffset(img image.Image, w, h int) (int, int) {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	freeX := bounds.Dx() - w
+	freeY := bounds.Dy() - h
+	const steps = 20
+
+	bestX, bestY := freeX/2, freeY/2
+	bestScore := -1.0
+	for i := 0; i <= steps; i++ {
+		x := freeX * i / steps
+		y := freeY * i / steps
+		score := regionBusyness(rgba, image.Rect(x, y, x+w, y+h))
+		if score > bestScore {
+			bestX, bestY = x, y
+			bestScore = score
+		}
+	}
+	return bestX, bestY
+}
+
+// applyCrop crops the image when cropping is enabled
+func applyCrop(img image.Image, ctx *ImageContext) image.Image {
+	if !appData.Crop.Enabled {
+		return img
+	}
+	rect := cropRect(img, ctx)
+	ctx.Logf("cropped to %dx%d at %d,%d (%s, %s)", rect.Dx(), rect.Dy(), rect.Min.X, rect.Min.Y, appData.Crop.Ratio, appData.Crop.Anchor)
+	return imaging.Crop(img, rect)
+}
+
+// cropOutline returns the crop rectangle in percent for drawing on the preview
+func cropOutline(img image.Image, ctx *ImageContext) []PercentRect {
+	if !appData.Crop.Enabled {
+		return nil
+	}
+	bounds := img.Bounds()
+	rect := cropRect(img, ctx).Sub(bounds.Min)
+	w := float64(bounds.Dx())
+	h := float64(bounds.Dy())
+	return []PercentRect{{
+		X: float64(rect.Min.X) * 100 / w,
+		Y: float64(rect.Min.Y) * 100 / h,
+		W: float64(rect.Dx()) * 100 / w,
+		H: float64(rect.Dy()) * 100 / h,
+	}}
+}
+
+// createCropControls creates the crop settings
+func createCropControls() *fyne.Container {
+	enableCheck := widget.NewCheck("Crop Before Watermarking", func(checked bool) {
+		appData.Crop.Enabled = checked
+		updatePreview()
+	})
+	enableCheck.SetChecked(appData.Crop.Enabled)
+
+	customW := widget.NewEntry()
+	customW.SetText(strconv.FormatFloat(appData.Crop.CustomW, 'f', -1, 64))
+	customW.OnChanged = func(text string) {
+		if value, err := strconv.ParseFloat(text, 64); err == nil && value > 0 {
+			appData.Crop.CustomW = value
+			updatePreview()
+		}
+	}
+
+	customH := widget.NewEntry()
+	customH.SetText(strconv.FormatFloat(appData.Crop.CustomH, 'f', -1, 64))
+	customH.OnChanged = func(text string) {
+		if value, err := strconv.ParseFloat(text, 64); err == nil && value > 0 {
+			appData.Crop.CustomH = value
+			updatePreview()
+		}
+	}
+
+	ratioSelect := widget.NewSelect([]string{"1:1", "4:5", "16:9", "custom"}, func(value string) {
+		appData.Crop.Ratio = value
+		updatePreview()
+	})
+	ratioSelect.SetSelected(appData.Crop.Ratio)
+
+	anchors := append(append([]string{}, gridPositions...), "manual", "smart")
+	anchorSelect := widget.NewSelect(anchors, func(value string) {
+		appData.Crop.Anchor = value
+		updatePreview()
+	})
+	anchorSelect.SetSelected(appData.Crop.Anchor)
+
+	placeBtn := widget.NewButton("Place Crop Box on Preview", func() {
+		if globalPreviewWidget == nil || appData.CurrentImage >= len(appData.Images) {
+			return
+		}
+		path := appData.Images[appData.CurrentImage]
+		globalPreviewWidget.SelectSourceRegion(func(box PercentRect) {
+			if appData.CropBoxes == nil {
+				appData.CropBoxes = make(map[string]PercentRect)
+			}
+			appData.CropBoxes[path] = box
+			appData.Crop.Anchor = "manual"
+			anchorSelect.SetSelected("manual")
+			updatePreview()
+		})
+	})
+
+	return container.NewVBox(
+		enableCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Aspect Ratio:"),
+			ratioSelect,
+			widget.NewLabel("Custom W:H:"),
+			container.NewGridWithColumns(2, customW, customH),
+			widget.NewLabel("Anchor:"),
+			anchorSelect,
+		),
+		placeBtn,
+	)
+}
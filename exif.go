@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// EXIF tags handled by the exporter
+const (
+	tagImageWidth      = 0x0100
+	tagImageLength     = 0x0101
+	tagOrientation     = 0x0112
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagInteropIFD      = 0xA005
+	tagPixelXDimension = 0xA002
+	tagPixelYDimension = 0xA003
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+	tagMakerNote       = 0x927C
+)
+
+// EXIF value types
+const (
+	exifByte      = 1
+	exifASCII     = 2
+	exifShort     = 3
+	exifLong      = 4
+	exifRational  = 5
+	exifSByte     = 6
+	exifUndefined = 7
+	exifSShort    = 8
+	exifSLong     = 9
+	exifSRational = 10
+	exifFloat     = 11
+	exifDouble    = 12
+	exifIFDType   = 13
+)
+
+// exifTypeSizes maps each value type to its size in bytes
+var exifTypeSizes = map[uint16]int{
+	exifByte: 1, exifASCII: 1, exifShort: 2, exifLong: 4, exifRational: 8,
+	exifSByte: 1, exifUndefined: 1, exifSShort: 2, exifSLong: 4,
+	exifSRational: 8, exifFloat: 4, exifDouble: 8, exifIFDType: 4,
+}
+
+// exifEntry is one tag of an image file directory. Data holds the raw value
+// bytes in the block's byte order.
+type exifEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+// exifData is a parsed EXIF (TIFF) block split into its directories. Pointer
+// tags between directories are rebuilt when the block is encoded.
+type exifData struct {
+	order     binary.ByteOrder
+	ifd0      []exifEntry
+	exif      []exifEntry
+	gps       []exifEntry
+	interop   []exifEntry
+	ifd1      []exifEntry
+	thumbnail []byte
+}
+
+// parseExif parses a TIFF-structured EXIF block
+func parseExif(b []byte) (*exifData, error) {
+	if len(b) < 8 {
+		return nil, errors.New("exif: block too short")
+	}
+
+	e := &exifData{}
+	switch string(b[:2]) {
+	case "II":
+		e.order = binary.LittleEndian
+	case "MM":
+		e.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid byte order")
+	}
+	if e.order.Uint16(b[2:]) != 42 {
+		return nil, errors.New("exif: invalid TIFF header")
+	}
+
+	visited := map[uint32]bool{}
+	ifd0, next, err := e.readIFD(b, e.order.Uint32(b[4:]), visited)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range ifd0 {
+		switch entry.Tag {
+		case tagExifIFD:
+			e.exif, _, err = e.readIFD(b, e.pointer(entry), visited)
+		case tagGPSIFD:
+			e.gps, _, err = e.readIFD(b, e.pointer(entry), visited)
+		default:
+			e.ifd0 = append(e.ifd0, entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	exifEntries := e.exif
+	e.exif = nil
+	for _, entry := range exifEntries {
+		if entry.Tag == tagInteropIFD {
+			if e.interop, _, err = e.readIFD(b, e.pointer(entry), visited); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e.exif = append(e.exif, entry)
+	}
+
+	// IFD1 holds the embedded thumbnail
+	if next != 0 {
+		ifd1, _, err := e.readIFD(b, next, visited)
+		if err != nil {
+			return nil, err
+		}
+		var thumbOffset, thumbLength uint32
+		for _, entry := range ifd1 {
+			switch entry.Tag {
+			case tagThumbnailOffset:
+				thumbOffset = e.pointer(entry)
+			case tagThumbnailLength:
+				thumbLength = e.pointer(entry)
+			default:
+				e.ifd1 = append(e.ifd1, entry)
+			}
+		}
+		if thumbLength > 0 && uint64(thumbOffset)+uint64(thumbLength) <= uint64(len(b)) {
+			e.thumbnail = append([]byte(nil), b[thumbOffset:thumbOffset+thumbLength]...)
+		}
+	}
+
+	return e, nil
+}
+
+// readIFD reads the directory at offset and returns its entries and the
+// offset of the next directory
+func (e *exifData) readIFD(b []byte, offset uint32, visited map[uint32]bool) ([]exifEntry, uint32, error) {
+	if visited[offset] {
+		return nil, 0, errors.New("exif: directory loop")
+	}
+	visited[offset] = true
+	if uint64(offset)+2 > uint64(len(b)) {
+		return nil, 0, errors.New("exif: directory out of range")
+	}
+
+	count := int(e.order.Uint16(b[offset:]))
+	end := uint64(offset) + 2 + uint64(count)*12
+	if end+4 > uint64(len(b)) {
+		return nil, 0, errors.New("exif: directory out of range")
+	}
+
+	entries := make([]exifEntry, 0, count)
+	for i := 0; i < count; i++ {
+		p := b[int(offset)+2+i*12:]
+		entry := exifEntry{
+			Tag:   e.order.Uint16(p),
+			Type:  e.order.Uint16(p[2:]),
+			Count: e.order.Uint32(p[4:]),
+		}
+		size, ok := exifTypeSizes[entry.Type]
+		if !ok {
+			continue // skip unknown types rather than failing the whole block
+		}
+		n := uint64(size) * uint64(entry.Count)
+		if n <= 4 {
+			entry.Data = append([]byte(nil), p[8:8+n]...)
+		} else {
+			valueOffset := uint64(e.order.Uint32(p[8:]))
+			if valueOffset+n > uint64(len(b)) {
+				continue
+			}
+			entry.Data = append([]byte(nil), b[valueOffset:valueOffset+n]...)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, e.order.Uint32(b[end:]), nil
+}
+
+// pointer reads a LONG or IFD value used as an offset
+func (e *exifData) pointer(entry exifEntry) uint32 {
+	switch {
+	case len(entry.Data) >= 4:
+		return e.order.Uint32(entry.Data)
+	case len(entry.Data) >= 2:
+		return uint32(e.order.Uint16(entry.Data))
+	}
+	return 0
+}
+
+// encode serializes the block, rebuilding every directory pointer
+func (e *exifData) encode() []byte {
+	long := func(tag uint16) exifEntry {
+		return exifEntry{Tag: tag, Type: exifLong, Count: 1, Data: make([]byte, 4)}
+	}
+
+	exifEntries := append([]exifEntry(nil), e.exif...)
+	if len(e.interop) > 0 {
+		exifEntries = append(exifEntries, long(tagInteropIFD))
+	}
+	ifd0 := append([]exifEntry(nil), e.ifd0...)
+	if len(exifEntries) > 0 {
+		ifd0 = append(ifd0, long(tagExifIFD))
+	}
+	if len(e.gps) > 0 {
+		ifd0 = append(ifd0, long(tagGPSIFD))
+	}
+	ifd1 := append([]exifEntry(nil), e.ifd1...)
+	if len(e.thumbnail) > 0 {
+		ifd1 = append(ifd1, long(tagThumbnailOffset), long(tagThumbnailLength))
+	}
+
+	// Lay out the directories one after another
+	offset := uint32(8)
+	place := func(entries []exifEntry) uint32 {
+		if len(entries) == 0 {
+			return 0
+		}
+		at := offset
+		offset += ifdSize(entries)
+		return at
+	}
+	ifd0Offset := place(ifd0)
+	exifOffset := place(exifEntries)
+	interopOffset := place(e.interop)
+	gpsOffset := place(e.gps)
+	ifd1Offset := place(ifd1)
+	thumbOffset := offset
+
+	setPointer := func(entries []exifEntry, tag uint16, value uint32) {
+		for i := range entries {
+			if entries[i].Tag == tag {
+				e.order.PutUint32(entries[i].Data, value)
+			}
+		}
+	}
+	setPointer(ifd0, tagExifIFD, exifOffset)
+	setPointer(ifd0, tagGPSIFD, gpsOffset)
+	setPointer(exifEntries, tagInteropIFD, interopOffset)
+	setPointer(ifd1, tagThumbnailOffset, thumbOffset)
+	setPointer(ifd1, tagThumbnailLength, uint32(len(e.thumbnail)))
+
+	var buf bytes.Buffer
+	if e.order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	header := make([]byte, 6)
+	e.order.PutUint16(header, 42)
+	e.order.PutUint32(header[2:], ifd0Offset)
+	buf.Write(header)
+
+	e.writeIFD(&buf, ifd0, ifd0Offset, ifd1Offset)
+	e.writeIFD(&buf, exifEntries, exifOffset, 0)
+	e.writeIFD(&buf, e.interop, interopOffset, 0)
+	e.writeIFD(&buf, e.gps, gpsOffset, 0)
+	e.writeIFD(&buf, ifd1, ifd1Offset, 0)
+	buf.Write(e.thumbnail)
+
+	return buf.Bytes()
+}
+
+// ifdSize returns the encoded size of a directory including its value area
+func ifdSize(entries []exifEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, entry := range entries {
+		if n := uint32(len(entry.Data)); n > 4 {
+			size += n + n%2
+		}
+	}
+	return size
+}
+
+// writeIFD writes a directory that starts at offset into buf
+func (e *exifData) writeIFD(buf *bytes.Buffer, entries []exifEntry, offset, next uint32) {
+	if len(entries) == 0 {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+
+	head := make([]byte, 2+12*len(entries)+4)
+	var values bytes.Buffer
+	valueOffset := offset + uint32(len(head))
+
+	e.order.PutUint16(head, uint16(len(entries)))
+	for i, entry := range entries {
+		p := head[2+i*12:]
+		e.order.PutUint16(p, entry.Tag)
+		e.order.PutUint16(p[2:], entry.Type)
+		e.order.PutUint32(p[4:], entry.Count)
+		if len(entry.Data) <= 4 {
+			copy(p[8:12], entry.Data)
+			continue
+		}
+		e.order.PutUint32(p[8:], valueOffset+uint32(values.Len()))
+		values.Write(entry.Data)
+		if len(entry.Data)%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	e.order.PutUint32(head[len(head)-4:], next)
+
+	buf.Write(head)
+	buf.Write(values.Bytes())
+}
+
+// find returns the entry for tag in a directory
+func findExifEntry(entries []exifEntry, tag uint16) (exifEntry, bool) {
+	for _, entry := range entries {
+		if entry.Tag == tag {
+			return entry, true
+		}
+	}
+	return exifEntry{}, false
+}
+
+// setExifEntry replaces or adds an entry in a directory
+func setExifEntry(entries *[]exifEntry, entry exifEntry) {
+	for i := range *entries {
+		if (*entries)[i].Tag == entry.Tag {
+			(*entries)[i] = entry
+			return
+		}
+	}
+	*entries = append(*entries, entry)
+}
+
+// removeExifEntry deletes tag from a directory and reports whether it existed
+func removeExifEntry(entries *[]exifEntry, tag uint16) bool {
+	for i := range *entries {
+		if (*entries)[i].Tag == tag {
+			*entries = append((*entries)[:i], (*entries)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// shortEntry creates a SHORT entry
+func (e *exifData) shortEntry(tag uint16, value uint16) exifEntry {
+	data := make([]byte, 2)
+	e.order.PutUint16(data, value)
+	return exifEntry{Tag: tag, Type: exifShort, Count: 1, Data: data}
+}
+
+// longEntry creates a LONG entry
+func (e *exifData) longEntry(tag uint16, value uint32) exifEntry {
+	data := make([]byte, 4)
+	e.order.PutUint32(data, value)
+	return exifEntry{Tag: tag, Type: exifLong, Count: 1, Data: data}
+}
+
+// asciiEntry creates a NUL-terminated ASCII entry
+func asciiEntry(tag uint16, value string) exifEntry {
+	data := append([]byte(value), 0)
+	return exifEntry{Tag: tag, Type: exifASCII, Count: uint32(len(data)), Data: data}
+}
+
+// dropMakerNote removes the MakerNote and reports whether there was one. Most
+// vendors store offsets in it relative to the original TIFF header, which
+// point at the wrong data once encode moves the directories.
+func (e *exifData) dropMakerNote() bool {
+	for i, entry := range e.exif {
+		if entry.Tag == tagMakerNote {
+			e.exif = append(e.exif[:i], e.exif[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// normalize marks the image as upright and records its pixel dimensions
+func (e *exifData) normalize(width, height int) {
+	setExifEntry(&e.ifd0, e.shortEntry(tagOrientation, 1))
+	if _, ok := findExifEntry(e.ifd0, tagImageWidth); ok {
+		setExifEntry(&e.ifd0, e.longEntry(tagImageWidth, uint32(width)))
+		setExifEntry(&e.ifd0, e.longEntry(tagImageLength, uint32(height)))
+	}
+	if len(e.exif) > 0 {
+		setExifEntry(&e.exif, e.longEntry(tagPixelXDimension, uint32(width)))
+		setExifEntry(&e.exif, e.longEntry(tagPixelYDimension, uint32(height)))
+	}
+}
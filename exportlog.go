@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportLogName is the file written to the output folder after a batch export
+const exportLogName = "export_log.txt"
+
+// ImageContext describes the image currently being watermarked and collects
+// the decisions made while processing it
+type ImageContext struct {
+	Path     string
+	Index    int
+	Total    int
+	Position string
+	Notes    []string
+	Warnings int
+
+	// Size is the size of the image the watermark is drawn on
+	Size image.Point
+
+	// Row and Vars come from the manifest row being exported
+	Row  int
+	Vars map[string]string
+
+	// usedNames holds the output names already written in this export
+	usedNames map[string]bool
+
+	// OutputPath is the written file and Sidecars the files written next to
+	// it; Marked and MarkID describe the invisible watermark hidden in it
+	OutputPath string
+	Sidecars   []string
+	Marked     bool
+	MarkID     uint32
+
+	// Recipient and Fingerprint are set when the export is fingerprinted
+	Recipient   string
+	Fingerprint uint32
+
+	// exif caches the source EXIF block for text tokens
+	exif       *exifData
+	exifLoaded bool
+}
+
+// Logf records a note about how the image was processed
+func (ctx *ImageContext) Logf(format string, args ...interface{}) {
+	if ctx == nil {
+		return
+	}
+	ctx.Notes = append(ctx.Notes, fmt.Sprintf(format, args...))
+}
+
+// Warnf records a note that needs the user's attention
+func (ctx *ImageContext) Warnf(format string, args ...interface{}) {
+	if ctx == nil {
+		return
+	}
+	ctx.Warnings++
+	ctx.Logf("WARNING: "+format, args...)
+}
+
+// ExportLog collects the notes of every exported image
+type ExportLog struct {
+	lines    []string
+	warnings int
+}
+
+// NewExportLog creates a new export log
+func NewExportLog() *ExportLog {
+	return &ExportLog{
+		lines: []string{"Export started " + time.Now().Format("2006-01-02 15:04:05")},
+	}
+}
+
+// Add appends the notes recorded for an image
+func (l *ExportLog) Add(ctx *ImageContext) {
+	name := filepath.Base(ctx.Path)
+	l.warnings += ctx.Warnings
+	if len(ctx.Notes) == 0 {
+		l.lines = append(l.lines, name+": exported")
+		return
+	}
+	for _, note := range ctx.Notes {
+		l.lines = append(l.lines, name+": "+note)
+	}
+}
+
+// Save writes the log into the given folder
+func (l *ExportLog) Save(folder string) error {
+	data := strings.Join(l.lines, "\n") + "\n"
+	return os.WriteFile(filepath.Join(folder, exportLogName), []byte(data), 0644)
+}
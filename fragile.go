@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// FragileConfig holds the settings of the fragile tamper detection mark
+type FragileConfig struct {
+	Enabled bool
+	Key     string
+}
+
+const (
+	// fragileBlock is the side of the blocks that are signed separately, and
+	// so the resolution of the tamper heatmap
+	fragileBlock = 8
+
+	// fragileUnmarked is the share of failing blocks above which the image
+	// is reported as not carrying the mark at all
+	fragileUnmarked = 0.9
+)
+
+// fragileMAC signs a block of pixels with their least significant RGB bits
+// cleared. The block position and image size are signed too, so blocks
+// can't be moved around or copied into a different image.
+func fragileMAC(img *image.NRGBA, block image.Rectangle, key string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	var header [16]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(img.Bounds().Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(img.Bounds().Dy()))
+	binary.BigEndian.PutUint32(header[8:], uint32(block.Min.X))
+	binary.BigEndian.PutUint32(header[12:], uint32(block.Min.Y))
+	mac.Write(header[:])
+
+	row := make([]byte, 0, block.Dx()*4)
+	for y := block.Min.Y; y < block.Max.Y; y++ {
+		row = row[:0]
+		for x := block.Min.X; x < block.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			row = append(row, p[0]&^1, p[1]&^1, p[2]&^1, p[3])
+		}
+		mac.Write(row)
+	}
+	return mac.Sum(nil)
+}
+
+// fragileBits visits the RGB values of a block that carry its signature.
+// A full block has 192 of them; only the first 256 of a larger one are used.
+func fragileBits(img *image.NRGBA, block image.Rectangle, visit func(i int, value *uint8)) {
+	i := 0
+	for y := block.Min.Y; y < block.Max.Y; y++ {
+		for x := block.Min.X; x < block.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			for c := 0; c < 3 && i < sha256.Size*8; c++ {
+				visit(i, &p[c])
+				i++
+			}
+		}
+	}
+}
+
+// fragileBlocks returns the blocks of an image, row by row
+func fragileBlocks(bounds image.Rectangle) (blocks []image.Rectangle, cols, rows int) {
+	cols = (bounds.Dx() + fragileBlock - 1) / fragileBlock
+	rows = (bounds.Dy() + fragileBlock - 1) / fragileBlock
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			block := image.Rect(bx*fragileBlock, by*fragileBlock, (bx+1)*fragileBlock, (by+1)*fragileBlock)
+			blocks = append(blocks, block.Intersect(bounds))
+		}
+	}
+	return blocks, cols, rows
+}
+
+// applyFragileMark writes the signature of every block into its least
+// significant RGB bits. It must be the last change to the pixels, and only
+// lossless output keeps it.
+func applyFragileMark(img image.Image, ctx *ImageContext) image.Image {
+	cfg := appData.Fragile
+	if !cfg.Enabled {
+		return img
+	}
+	if cfg.Key == "" {
+		ctx.Warnf("fragile mark skipped: no key set")
+		return img
+	}
+	if appData.OutputFormat != "PNG" {
+		ctx.Warnf("fragile mark skipped: JPEG compression would break it, export as PNG")
+		return img
+	}
+
+	marked := imaging.Clone(img)
+	blocks, _, _ := fragileBlocks(marked.Bounds())
+	for _, block := range blocks {
+		sum := fragileMAC(marked, block, cfg.Key)
+		fragileBits(marked, block, func(i int, value *uint8) {
+			*value = *value&^1 | sum[i/8]>>(7-i%8)&1
+		})
+	}
+	ctx.Logf("fragile mark added (%d blocks)", len(blocks))
+	return marked
+}
+
+// tamperReport is the outcome of checking the fragile mark
+type tamperReport struct {
+	Cols, Rows int
+	Modified   []bool // per block, row by row
+	Count      int
+	Area       image.Rectangle // bounds of the modified blocks, in pixels
+}
+
+// Unmarked reports whether nearly every block fails, which means the image
+// was never marked with this key, or was re-encoded, resized or cropped
+func (r tamperReport) Unmarked() bool {
+	return float64(r.Count) > fragileUnmarked*float64(len(r.Modified))
+}
+
+// String formats the report
+func (r tamperReport) String() string {
+	switch {
+	case r.Unmarked():
+		return fmt.Sprintf("No intact fragile watermark found (%d of %d blocks fail).\n"+
+			"The key is wrong, the image was never marked, or it was re-encoded, resized or cropped.",
+			r.Count, len(r.Modified))
+	case r.Count == 0:
+		return fmt.Sprintf("Image is unmodified: all %d blocks verify", len(r.Modified))
+	}
+	return fmt.Sprintf("Image was modified: %d of %d blocks (%.1f%%) fail\nModified area: %d,%d to %d,%d",
+		r.Count, len(r.Modified), float64(r.Count)*100/float64(len(r.Modified)),
+		r.Area.Min.X, r.Area.Min.Y, r.Area.Max.X, r.Area.Max.Y)
+}
+
+// verifyFragileMark checks the signature of every block
+func verifyFragileMark(img image.Image, key string) tamperReport {
+	src := imaging.Clone(img)
+	blocks, cols, rows := fragileBlocks(src.Bounds())
+	report := tamperReport{Cols: cols, Rows: rows, Modified: make([]bool, len(blocks))}
+	for b, block := range blocks {
+		sum := fragileMAC(src, block, key)
+		fragileBits(src, block, func(i int, value *uint8) {
+			if *value&1 != sum[i/8]>>(7-i%8)&1 {
+				report.Modified[b] = true
+			}
+		})
+		if report.Modified[b] {
+			report.Count++
+			report.Area = report.Area.Union(block)
+		}
+	}
+	return report
+}
+
+// tamperHeatmap shows the image dimmed in gray with the modified blocks in red
+func tamperHeatmap(img image.Image, report tamperReport) *image.NRGBA {
+	heatmap := imaging.Grayscale(img)
+	blocks, _, _ := fragileBlocks(heatmap.Bounds())
+	for b, block := range blocks {
+		for y := block.Min.Y; y < block.Max.Y; y++ {
+			for x := block.Min.X; x < block.Max.X; x++ {
+				p := heatmap.Pix[heatmap.PixOffset(x, y):]
+				gray := p[0] / 2
+				if report.Modified[b] {
+					p[0], p[1], p[2] = 128+gray, gray/2, gray/2
+				} else {
+					p[0], p[1], p[2] = gray, gray, gray
+				}
+				p[3] = 255
+			}
+		}
+	}
+	return heatmap
+}
+
+// tamperHeatmapPath is where a heatmap for path is written by default
+func tamperHeatmapPath(path, folder string) string {
+	if folder == "" {
+		folder = filepath.Dir(path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return filepath.Join(folder, name+"_tamper.png")
+}
+
+// isLossyImage reports whether a file is stored in a format that can't
+// carry the fragile mark
+func isLossyImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// ShowTamperDialog asks for an exported image and shows which blocks were
+// changed since export
+func (ec *EnhancedControls) ShowTamperDialog() {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, ec.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		defer reader.Close()
+		path := reader.URI().Path()
+
+		keyEntry := widget.NewPasswordEntry()
+		keyEntry.SetText(appData.Fragile.Key)
+		dialog.ShowForm("Verify "+filepath.Base(path), "Verify", "Cancel",
+			[]*widget.FormItem{widget.NewFormItem("Key", keyEntry)}, func(ok bool) {
+				if !ok {
+					return
+				}
+				if keyEntry.Text == "" {
+					dialog.ShowError(errors.New("Error: Key cannot be empty"), ec.window)
+					return
+				}
+				img, err := imaging.Open(path)
+				if err != nil {
+					dialog.ShowError(err, ec.window)
+					return
+				}
+				report := verifyFragileMark(img, keyEntry.Text)
+				ec.showTamperReport(path, img, report)
+			}, ec.window)
+	}, ec.window)
+}
+
+// showTamperReport shows the heatmap and offers to save it
+func (ec *EnhancedControls) showTamperReport(path string, img image.Image, report tamperReport) {
+	text := report.String()
+	if isLossyImage(path) {
+		text += "\nJPEG files can't keep the fragile mark; every block of a JPEG fails."
+	}
+
+	heatmap := tamperHeatmap(img, report)
+	preview := canvas.NewImageFromImage(heatmap)
+	preview.FillMode = canvas.ImageFillContain
+	preview.SetMinSize(fyne.NewSize(480, 360))
+
+	saveBtn := widget.NewButton("Save Heatmap...", func() {
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, ec.window)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			writer.Close()
+			if err := imaging.Save(heatmap, writer.URI().Path()); err != nil {
+				dialog.ShowError(err, ec.window)
+			}
+		}, ec.window)
+		saveDialog.SetFileName(filepath.Base(tamperHeatmapPath(path, "")))
+		saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".png"}))
+		saveDialog.Show()
+	})
+
+	content := container.NewBorder(widget.NewLabel(text), saveBtn, nil, nil, preview)
+	dialog.ShowCustom("Tamper Check: "+filepath.Base(path), "Close", content, ec.window)
+}
+
+// tamperCommand implements "tamper -key KEY [-out DIR] FILE..."
+func tamperCommand(args []string) int {
+	flags := flag.NewFlagSet("tamper", flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("WATERMARK_FRAGILE_KEY"), "secret key of the fragile watermark (or $WATERMARK_FRAGILE_KEY)")
+	out := flags.String("out", "", "folder for the heatmaps (default: next to each image)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: watermark-app tamper -key KEY [-out DIR] IMAGE...")
+		fmt.Fprintln(flags.Output(), "Writes NAME_tamper.png with modified blocks in red when an image fails.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *key == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		img, err := imaging.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 2
+		}
+		report := verifyFragileMark(img, *key)
+		fmt.Printf("%s:\n  %s\n", path, strings.ReplaceAll(report.String(), "\n", "\n  "))
+		if isLossyImage(path) {
+			fmt.Println("  JPEG files can't keep the fragile mark")
+		}
+		if report.Count == 0 {
+			continue
+		}
+		code = 1
+		if !report.Unmarked() {
+			heatmapPath := tamperHeatmapPath(path, *out)
+			if err := imaging.Save(tamperHeatmap(img, report), heatmapPath); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", heatmapPath, err)
+				return 2
+			}
+			fmt.Printf("  Heatmap: %s\n", heatmapPath)
+		}
+	}
+	return code
+}
+
+// createFragileControls creates the fragile watermark settings
+func (ec *EnhancedControls) createFragileControls() *fyne.Container {
+	cfg := &appData.Fragile
+
+	enableCheck := widget.NewCheck("Add fragile tamper detection mark on export", func(checked bool) {
+		cfg.Enabled = checked
+	})
+	enableCheck.SetChecked(cfg.Enabled)
+
+	keyEntry := widget.NewPasswordEntry()
+	keyEntry.SetPlaceHolder("Secret key")
+	keyEntry.SetText(cfg.Key)
+	keyEntry.OnChanged = func(text string) {
+		cfg.Key = text
+	}
+
+	return container.NewVBox(
+		widget.NewLabel("Fragile Watermark"),
+		widget.NewSeparator(),
+		enableCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Key:"),
+			keyEntry,
+		),
+		widget.NewLabel("Any pixel edit breaks the mark in the 8x8 blocks it touches.\n"+
+			"Only PNG output keeps it: JPEG exports are not marked, and\n"+
+			"re-saving, resizing or cropping an export makes every block fail."),
+		widget.NewButton("Verify Evidence Image...", func() {
+			ec.ShowTamperDialog()
+		}),
+	)
+}
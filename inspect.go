@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	// inspectMaxCrop is the largest share of each edge assumed cropped away
+	inspectMaxCrop = 0.08
+
+	// inspectMaxBER is the bit-error rate above which a CRC match is treated
+	// as chance rather than a detection
+	inspectMaxBER = 0.3
+)
+
+// inspectResult is the outcome of looking for the invisible mark
+type inspectResult struct {
+	markReading
+	Detected   bool
+	Confidence float64 // 0-1
+	Crop       [4]float64
+	Matches    []ExportRecord
+}
+
+// inspectSteps are the crop search resolutions. The mark still reads when
+// each edge is off by about half a percent, so the first pass tries every
+// combination at 1% and the later passes refine the best one.
+var inspectSteps = []float64{0.01, 0.005, 0.0025}
+
+// inspectImage looks for the invisible mark. Crops move and stretch the mark
+// grid, so the cropped share of each edge is searched, coarse then fine.
+func inspectImage(img image.Image, key string) inspectResult {
+	plane := newLumaPlane(img)
+	layout := newMarkLayout(key)
+	w, h := float64(plane.w), float64(plane.h)
+
+	read := func(crop [4]float64) markReading {
+		// crop holds the share removed from the left, top, right and bottom
+		fullW := w / (1 - crop[0] - crop[2])
+		fullH := h / (1 - crop[1] - crop[3])
+		x0, y0 := -crop[0]*fullW, -crop[1]*fullH
+		return readMarkGrid(plane.grid(x0, y0, x0+fullW, y0+fullH), layout)
+	}
+
+	// The score measures how well the repeated bits agree, so it peaks at the
+	// right crop whether or not the payload is known
+	var best inspectResult
+	best.markReading = read(best.Crop)
+	for pass, step := range inspectSteps {
+		center := best.Crop
+		span := 1
+		if pass == 0 {
+			center = [4]float64{}
+			span = int(math.Round(inspectMaxCrop / step))
+		}
+		var crop [4]float64
+		var search func(edge int)
+		search = func(edge int) {
+			if edge == 4 {
+				if reading := read(crop); reading.Score > best.Score {
+					best.markReading, best.Crop = reading, crop
+				}
+				return
+			}
+			for i := -span; i <= span; i++ {
+				crop[edge] = center[edge] + float64(i)*step
+				if crop[edge] < 0 || crop[edge] > inspectMaxCrop {
+					continue
+				}
+				search(edge + 1)
+			}
+		}
+		search(0)
+	}
+
+	best.Detected = best.Valid && best.BER < inspectMaxBER
+	if best.Detected {
+		best.Confidence = math.Max(0, math.Min(1, 1-best.BER/inspectMaxBER)) * math.Max(0, best.Score)
+		best.Matches = loadExportRegistry().Find(best.Payload)
+	}
+	return best
+}
+
+// String formats the result as a report
+func (r inspectResult) String() string {
+	var lines []string
+	if r.Detected {
+		lines = append(lines,
+			"Invisible watermark found",
+			fmt.Sprintf("Owner ID: %d (0x%08X)", r.Payload.Owner, r.Payload.Owner),
+			fmt.Sprintf("Image ID: 0x%08X", r.Payload.Image))
+	} else {
+		lines = append(lines, "No invisible watermark found for this key")
+	}
+	lines = append(lines,
+		fmt.Sprintf("Bit-error rate: %.1f%%", r.BER*100),
+		fmt.Sprintf("Confidence: %.0f%%", r.Confidence*100))
+	if r.Detected && r.Crop != [4]float64{} {
+		lines = append(lines, fmt.Sprintf("Estimated crop: left %.1f%%, top %.1f%%, right %.1f%%, bottom %.1f%%",
+			r.Crop[0]*100, r.Crop[1]*100, r.Crop[2]*100, r.Crop[3]*100))
+	}
+	if r.Detected {
+		if len(r.Matches) == 0 {
+			lines = append(lines, "Known export: none recorded")
+		}
+		for _, record := range r.Matches {
+			line := fmt.Sprintf("Known export: %s from %s", filepath.Base(record.Output), filepath.Base(record.Source))
+			if record.Recipient != "" {
+				line += " for " + record.Recipient
+			}
+			lines = append(lines, line+" on "+record.Exported.Format("2006-01-02 15:04"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ShowInspectDialog asks for a suspect image and reports the invisible mark
+func (ec *EnhancedControls) ShowInspectDialog() {
+	ec.showInspectDialog("Inspect", inspectResult.String)
+}
+
+// showInspectDialog asks for an image and a key, reads the invisible mark
+// and shows the report
+func (ec *EnhancedControls) showInspectDialog(action string, report func(inspectResult) string) {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, ec.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		defer reader.Close()
+		path := reader.URI().Path()
+
+		keyEntry := widget.NewPasswordEntry()
+		keyEntry.SetText(appData.Invisible.Key)
+		dialog.ShowForm(action+" "+filepath.Base(path), action, "Cancel",
+			[]*widget.FormItem{widget.NewFormItem("Key", keyEntry)}, func(ok bool) {
+				if !ok {
+					return
+				}
+				if keyEntry.Text == "" {
+					dialog.ShowError(errors.New("Error: Key cannot be empty"), ec.window)
+					return
+				}
+				img, err := loadImage(path)
+				if err != nil {
+					dialog.ShowError(err, ec.window)
+					return
+				}
+
+				// The crop search takes a few seconds
+				busy := dialog.NewCustomWithoutButtons("Inspecting "+filepath.Base(path),
+					widget.NewProgressBarInfinite(), ec.window)
+				busy.Show()
+				go func() {
+					result := inspectImage(img, keyEntry.Text)
+					busy.Hide()
+					dialog.ShowInformation(action+" Result", report(result), ec.window)
+				}()
+			}, ec.window)
+	}, ec.window)
+}
+
+// inspectCommand implements "inspect -key KEY FILE..."
+func inspectCommand(args []string) int {
+	return runInspect("inspect", args, inspectResult.String, func(r inspectResult) bool { return r.Detected })
+}
+
+// runInspect reads the invisible mark of each image given on the command
+// line and prints the report. It returns 0 when found holds for any image.
+func runInspect(name string, args []string, report func(inspectResult) string, found func(inspectResult) bool) int {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("WATERMARK_KEY"), "secret key of the invisible watermark (or $WATERMARK_KEY)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: watermark-app %s -key KEY IMAGE...\n", name)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *key == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	code := 1
+	for _, path := range flags.Args() {
+		img, err := loadImage(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 2
+		}
+		result := inspectImage(img, *key)
+		fmt.Printf("%s:\n  %s\n", path, strings.ReplaceAll(report(result), "\n", "\n  "))
+		if found(result) {
+			code = 0
+		}
+	}
+	return code
+}
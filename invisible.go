@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"image"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// InvisibleConfig holds the settings of the invisible watermark, which hides
+// an owner ID and an image ID in the luminance of the photo
+type InvisibleConfig struct {
+	Enabled  bool
+	Key      string // secret key; the same key is needed to read the mark
+	OwnerID  uint32
+	Strength int // 1-100
+}
+
+const (
+	// The luminance is resampled to a markGrid x markGrid grid of 8x8 blocks,
+	// so each block covers a large area of the photo. The marked DCT
+	// coefficients are then far below the frequencies JPEG discards.
+	markGrid   = 256
+	markBlocks = markGrid / 8
+
+	markPayloadBits = 80 // owner ID, image ID and CRC-16
+	markPasses      = 4  // embedding passes to make up for masking and resampling losses
+)
+
+// markPairs are the coefficient pairs whose difference carries one bit
+var markPairs = [][2][2]int{
+	{{2, 3}, {3, 2}},
+	{{1, 4}, {4, 1}},
+}
+
+// markSlots is the number of bits embedded per image, each payload bit is
+// repeated about 25 times
+var markSlots = markBlocks * markBlocks * len(markPairs)
+
+// dctBasis holds the orthonormal 8-point DCT basis, dctBasis[u][x]
+var dctBasis = func() [8][8]float64 {
+	var basis [8][8]float64
+	for u := 0; u < 8; u++ {
+		scale := math.Sqrt(2.0 / 8)
+		if u == 0 {
+			scale = math.Sqrt(1.0 / 8)
+		}
+		for x := 0; x < 8; x++ {
+			basis[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return basis
+}()
+
+// markKernels hold the difference of the two basis functions of each pair,
+// so a slot is read with a single pass over its block
+var markKernels = func() [][8][8]float64 {
+	kernels := make([][8][8]float64, len(markPairs))
+	for p, pair := range markPairs {
+		a, b := pair[0], pair[1]
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				kernels[p][y][x] = dctBasis[a[0]][x]*dctBasis[a[1]][y] - dctBasis[b[0]][x]*dctBasis[b[1]][y]
+			}
+		}
+	}
+	return kernels
+}()
+
+// markPayload is the data carried by the invisible watermark
+type markPayload struct {
+	Owner uint32
+	Image uint32
+}
+
+// bits returns the payload followed by its CRC-16
+func (p markPayload) bits() []bool {
+	data := make([]byte, 10)
+	binary.BigEndian.PutUint32(data, p.Owner)
+	binary.BigEndian.PutUint32(data[4:], p.Image)
+	binary.BigEndian.PutUint16(data[8:], crc16(data[:8]))
+
+	bits := make([]bool, markPayloadBits)
+	for i := range bits {
+		bits[i] = data[i/8]&(0x80>>(i%8)) != 0
+	}
+	return bits
+}
+
+// payloadFromBits decodes bits and reports whether the CRC matches
+func payloadFromBits(bits []bool) (markPayload, bool) {
+	data := make([]byte, 10)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	payload := markPayload{
+		Owner: binary.BigEndian.Uint32(data),
+		Image: binary.BigEndian.Uint32(data[4:]),
+	}
+	return payload, binary.BigEndian.Uint16(data[8:]) == crc16(data[:8])
+}
+
+// crc16 computes the CRC-16/CCITT-FALSE checksum
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// markLayout is the keyed assignment of payload bits to coefficient pairs.
+// Without the key the positions and polarity of the bits are unknown.
+type markLayout struct {
+	bit  []int
+	flip []bool
+}
+
+// newMarkLayout derives the layout from the secret key
+func newMarkLayout(key string) markLayout {
+	h := fnv.New64a()
+	h.Write([]byte("invisible"))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	layout := markLayout{bit: make([]int, markSlots), flip: make([]bool, markSlots)}
+	for slot, p := range r.Perm(markSlots) {
+		layout.bit[slot] = p % markPayloadBits
+		layout.flip[slot] = r.Intn(2) == 1
+	}
+	return layout
+}
+
+// lumaPlane is the luminance of an image with a summed-area table for fast
+// area averages
+type lumaPlane struct {
+	w, h int
+	sum  []float64 // (w+1) x (h+1) summed-area table
+}
+
+// newLumaPlane computes the luminance of img
+func newLumaPlane(img image.Image) *lumaPlane {
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	l := &lumaPlane{w: w, h: h, sum: make([]float64, (w+1)*(h+1))}
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			i := y*src.Stride + x*4
+			row += 0.299*float64(src.Pix[i]) + 0.587*float64(src.Pix[i+1]) + 0.114*float64(src.Pix[i+2])
+			l.sum[(y+1)*(w+1)+x+1] = l.sum[y*(w+1)+x+1] + row
+		}
+	}
+	return l
+}
+
+// cellRange returns the pixels whose centers fall in [a, b), clamped to the
+// image. Cells outside the image use the nearest edge pixel.
+func cellRange(a, b float64, size int) (int, int) {
+	lo := int(math.Ceil(a - 0.5))
+	hi := int(math.Ceil(b - 0.5))
+	if hi <= lo {
+		lo = int(math.Floor((a + b) / 2))
+		hi = lo + 1
+	}
+	lo = max(0, min(size-1, lo))
+	hi = max(lo+1, min(size, hi))
+	return lo, hi
+}
+
+// grid averages the region (x0,y0)-(x1,y1) of the plane into a
+// markGrid x markGrid grid
+func (l *lumaPlane) grid(x0, y0, x1, y1 float64) []float64 {
+	var xs, ys [markGrid + 1][2]int
+	for i := 0; i < markGrid; i++ {
+		xs[i][0], xs[i][1] = cellRange(x0+(x1-x0)*float64(i)/markGrid, x0+(x1-x0)*float64(i+1)/markGrid, l.w)
+		ys[i][0], ys[i][1] = cellRange(y0+(y1-y0)*float64(i)/markGrid, y0+(y1-y0)*float64(i+1)/markGrid, l.h)
+	}
+
+	stride := l.w + 1
+	g := make([]float64, markGrid*markGrid)
+	for j := 0; j < markGrid; j++ {
+		ya, yb := ys[j][0], ys[j][1]
+		for i := 0; i < markGrid; i++ {
+			xa, xb := xs[i][0], xs[i][1]
+			total := l.sum[yb*stride+xb] - l.sum[ya*stride+xb] - l.sum[yb*stride+xa] + l.sum[ya*stride+xa]
+			g[j*markGrid+i] = total / float64((xb-xa)*(yb-ya))
+		}
+	}
+	return g
+}
+
+// blockDiff returns the coefficient difference of pair p in the 8x8 block at
+// (bx, by)
+func blockDiff(g []float64, bx, by, p int) float64 {
+	total := 0.0
+	for y := 0; y < 8; y++ {
+		row := g[(by*8+y)*markGrid+bx*8:]
+		kernel := &markKernels[p][y]
+		for x := 0; x < 8; x++ {
+			total += row[x] * kernel[x]
+		}
+	}
+	return total
+}
+
+// addCoef adds delta to coefficient (u, v) of a block in the spatial domain
+func addCoef(g []float64, bx, by int, uv [2]int, delta float64) {
+	u, v := uv[0], uv[1]
+	for y := 0; y < 8; y++ {
+		row := g[(by*8+y)*markGrid+bx*8:]
+		for x := 0; x < 8; x++ {
+			row[x] += delta * dctBasis[u][x] * dctBasis[v][y]
+		}
+	}
+}
+
+// invisibleImageID identifies an exported image in the invisible mark
+func invisibleImageID(ctx *ImageContext) uint32 {
+	if ctx.Fingerprint != 0 {
+		return ctx.Fingerprint
+	}
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Base(ctx.Path)))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(ctx.Row)))
+	return h.Sum32()
+}
+
+// invisibleMarkEnabled reports whether exports carry the invisible mark,
+// which fingerprinting needs too
+func invisibleMarkEnabled() bool {
+	return appData.Invisible.Enabled || appData.Fingerprint.Enabled
+}
+
+// applyInvisibleMark embeds the owner and image IDs into the image. Each
+// coefficient pair is pushed apart by a margin in the direction of its bit.
+func applyInvisibleMark(img image.Image, ctx *ImageContext) image.Image {
+	cfg := appData.Invisible
+	if !invisibleMarkEnabled() || cfg.Key == "" {
+		return img
+	}
+
+	payload := markPayload{Owner: cfg.OwnerID, Image: invisibleImageID(ctx)}
+	bits := payload.bits()
+	layout := newMarkLayout(cfg.Key)
+	margin := 4 + float64(max(1, min(100, cfg.Strength)))*0.4
+	maxChange := 3 * margin
+
+	marked := imaging.Clone(img)
+	w, h := marked.Bounds().Dx(), marked.Bounds().Dy()
+	if w < markBlocks || h < markBlocks {
+		ctx.Logf("invisible mark skipped: image too small")
+		return img
+	}
+
+	mask := activityMask(newLumaPlane(img).grid(0, 0, float64(w), float64(h)))
+
+	for pass := 0; pass < markPasses; pass++ {
+		g := newLumaPlane(marked).grid(0, 0, float64(w), float64(h))
+		delta := make([]float64, markGrid*markGrid)
+		weak := 0
+		for by := 0; by < markBlocks; by++ {
+			for bx := 0; bx < markBlocks; bx++ {
+				for p, pair := range markPairs {
+					slot := (by*markBlocks+bx)*len(markPairs) + p
+					sign := -1.0
+					if bits[layout.bit[slot]] != layout.flip[slot] {
+						sign = 1
+					}
+					d := sign * blockDiff(g, bx, by, p)
+					if d >= margin {
+						continue
+					}
+					weak++
+					change := math.Min(margin-d, maxChange) / 2
+					addCoef(delta, bx, by, pair[0], sign*change)
+					addCoef(delta, bx, by, pair[1], -sign*change)
+				}
+			}
+		}
+		if weak == 0 {
+			break
+		}
+		for i := range delta {
+			delta[i] *= mask[i]
+		}
+		addGridDelta(marked, delta)
+	}
+
+	ctx.Marked, ctx.MarkID = true, payload.Image
+	ctx.Logf("invisible mark owner=%08X image=%08X strength=%d", payload.Owner, payload.Image, cfg.Strength)
+	return marked
+}
+
+// activityMask scales the change at each grid cell by the local texture,
+// measured as the luminance deviation of the 3x3 neighborhood. Flat areas
+// such as sky get little of the mark, where it would be visible.
+func activityMask(g []float64) []float64 {
+	mask := make([]float64, len(g))
+	for y := 0; y < markGrid; y++ {
+		for x := 0; x < markGrid; x++ {
+			sum, sumSq, n := 0.0, 0.0, 0.0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= markGrid || ny >= markGrid {
+						continue
+					}
+					v := g[ny*markGrid+nx]
+					sum += v
+					sumSq += v * v
+					n++
+				}
+			}
+			mean := sum / n
+			std := math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+			mask[y*markGrid+x] = math.Max(0.1, math.Min(1.25, std/8))
+		}
+	}
+	return mask
+}
+
+// addGridDelta upsamples a grid of luminance changes bilinearly and adds it
+// to every color channel
+func addGridDelta(img *image.NRGBA, delta []float64) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	sx := float64(markGrid) / float64(w)
+	sy := float64(markGrid) / float64(h)
+
+	for y := 0; y < h; y++ {
+		gy := math.Max(0, math.Min(markGrid-1, (float64(y)+0.5)*sy-0.5))
+		y0 := int(gy)
+		y1 := min(markGrid-1, y0+1)
+		fy := gy - float64(y0)
+		for x := 0; x < w; x++ {
+			gx := math.Max(0, math.Min(markGrid-1, (float64(x)+0.5)*sx-0.5))
+			x0 := int(gx)
+			x1 := min(markGrid-1, x0+1)
+			fx := gx - float64(x0)
+
+			d := (delta[y0*markGrid+x0]*(1-fx)+delta[y0*markGrid+x1]*fx)*(1-fy) +
+				(delta[y1*markGrid+x0]*(1-fx)+delta[y1*markGrid+x1]*fx)*fy
+
+			i := y*img.Stride + x*4
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = uint8(math.Max(0, math.Min(255, math.Round(float64(img.Pix[i+c])+d))))
+			}
+		}
+	}
+}
+
+// markReading is the result of reading the invisible mark from a grid
+type markReading struct {
+	Payload markPayload
+	Valid   bool    // the CRC matches
+	BER     float64 // share of repeated bits that disagree with the decoded payload
+	Score   float64 // mean normalized agreement, 0 (noise) to 1 (clean)
+}
+
+// readMarkGrid decodes the payload from a luminance grid by summing the
+// repeated bits
+func readMarkGrid(g []float64, layout markLayout) markReading {
+	soft := make([]float64, markPayloadBits)
+	slotValues := make([]float64, markSlots)
+	for by := 0; by < markBlocks; by++ {
+		for bx := 0; bx < markBlocks; bx++ {
+			for p := range markPairs {
+				slot := (by*markBlocks+bx)*len(markPairs) + p
+				d := blockDiff(g, bx, by, p)
+				if layout.flip[slot] {
+					d = -d
+				}
+				// Limit strong image structure so it can't outvote the other copies
+				d = math.Max(-25, math.Min(25, d))
+				slotValues[slot] = d
+				soft[layout.bit[slot]] += d
+			}
+		}
+	}
+
+	bits := make([]bool, markPayloadBits)
+	for i, v := range soft {
+		bits[i] = v > 0
+	}
+	reading := markReading{}
+	reading.Payload, reading.Valid = payloadFromBits(bits)
+
+	wrong, agreement, total := 0, 0.0, 0.0
+	for slot, d := range slotValues {
+		want := bits[layout.bit[slot]]
+		if (d > 0) != want {
+			wrong++
+		}
+		if want {
+			agreement += d
+		} else {
+			agreement -= d
+		}
+		total += math.Abs(d)
+	}
+	reading.BER = float64(wrong) / float64(markSlots)
+	if total > 0 {
+		reading.Score = agreement / total
+	}
+	return reading
+}
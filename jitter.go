@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"path/filepath"
+)
+
+// JitterConfig randomizes the watermark per image so identical marks cannot
+// be averaged out across a batch
+type JitterConfig struct {
+	Enabled  bool
+	Seed     string
+	Offset   int     // maximum position offset in pixels
+	Rotation float64 // maximum rotation change in degrees
+	Scale    int     // maximum scale change in percent
+	Opacity  int     // maximum opacity change in percentage points
+}
+
+// jitterParams are the randomized values applied to a single image
+type jitterParams struct {
+	DX       int
+	DY       int
+	Rotation float64
+	Scale    float64
+	Opacity  int
+}
+
+// computeJitter derives the jitter for an image from its file name and the
+// configured seed, so re-exports produce exactly the same marks
+func computeJitter(ctx *ImageContext) jitterParams {
+	params := jitterParams{Scale: 1}
+	cfg := appData.Watermark.Jitter
+	if ctx == nil {
+		return params
+	}
+	seed := cfg.Seed
+	if ctx.Fingerprint != 0 && appData.Fingerprint.Jitter {
+		// Every fingerprinted export gets its own variation
+		seed = fmt.Sprintf("%s\x00%08X", seed, ctx.Fingerprint)
+	} else if !cfg.Enabled {
+		return params
+	}
+
+	effective := seedFor(ctx, seed)
+	rng := rand.New(rand.NewSource(effective))
+	between := func(limit float64) float64 {
+		return (rng.Float64()*2 - 1) * limit
+	}
+
+	params.DX = int(between(float64(cfg.Offset)))
+	params.DY = int(between(float64(cfg.Offset)))
+	params.Rotation = between(cfg.Rotation)
+	params.Scale = 1 + between(float64(cfg.Scale))/100
+	params.Opacity = int(between(float64(cfg.Opacity)))
+
+	ctx.Logf("jitter seed=%016X (%q row %d) dx=%d dy=%d rotation=%.2f scale=%.3f opacity=%+d",
+		uint64(effective), seed, ctx.Row, params.DX, params.DY, params.Rotation, params.Scale, params.Opacity)
+	return params
+}
+
+// seedFor derives a deterministic seed from the file name, the manifest row
+// and a user seed, so every row exported from one file varies on its own
+func seedFor(ctx *ImageContext, seed string) int64 {
+	h := fnv.New64a()
+	if ctx != nil {
+		h.Write([]byte(filepath.Base(ctx.Path)))
+		if ctx.Row > 0 {
+			fmt.Fprintf(h, "\x00row %d", ctx.Row)
+		}
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(seed))
+	return int64(h.Sum64())
+}
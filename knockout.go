@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+// KnockoutConfig reveals an altered copy of the photo inside the watermark
+// shapes instead of painting a color on top
+type KnockoutConfig struct {
+	Enabled  bool
+	Effect   string // "blur", "desaturate" or "brighten"
+	Strength int    // 0-100
+}
+
+// knockoutApplies reports whether the current watermark uses knockout fill.
+// Only text and logo marks have glyph shapes worth knocking out.
+func knockoutApplies() bool {
+	if !appData.Watermark.Knockout.Enabled {
+		return false
+	}
+	t := watermarkType()
+	return t == "text" || t == "image"
+}
+
+// drawKnockout composites the layer onto img as a knockout: the layer's alpha
+// selects where the altered photo replaces the original
+func drawKnockout(img *image.RGBA, rect image.Rectangle, layer image.Image) {
+	area := rect.Intersect(img.Bounds())
+	if area.Empty() {
+		return
+	}
+
+	cfg := appData.Watermark.Knockout
+	strength := float64(max(0, min(100, cfg.Strength)))
+
+	var effected *image.NRGBA
+	source := area
+	switch cfg.Effect {
+	case "desaturate":
+		effected = imaging.AdjustSaturation(imaging.Crop(img, source), -strength)
+	case "brighten":
+		effected = imaging.AdjustBrightness(imaging.Crop(img, source), strength*0.8)
+	default:
+		// Take a margin around the area so blurring doesn't darken the edges
+		sigma := 0.5 + strength/8
+		margin := int(3 * sigma)
+		source = area.Inset(-margin).Intersect(img.Bounds())
+		effected = imaging.Blur(imaging.Crop(img, source), sigma)
+	}
+
+	layerMin := layer.Bounds().Min
+	draw.DrawMask(img, area,
+		effected, area.Min.Sub(source.Min),
+		layer, layerMin.Add(area.Min.Sub(rect.Min)),
+		draw.Over)
+}
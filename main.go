@@ -2,12 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"image"
 	"image/color"
-	"image/jpeg"
-	"image/png"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -19,6 +20,10 @@ import (
 	"github.com/disintegration/imaging"
 )
 
+// appVersion is recorded in provenance manifests. Release builds can set it
+// with -ldflags "-X main.appVersion=..."
+var appVersion = "1.0.0"
+
 // WatermarkConfig holds all watermark configuration
 type WatermarkConfig struct {
 	Text      string
@@ -31,6 +36,32 @@ type WatermarkConfig struct {
 	Rotation  float64
 	ImagePath string
 	IsImage   bool
+
+	// Type is "text", "image", "qr" or "barcode"
+	Type    string
+	QR      QRConfig
+	Barcode BarcodeConfig
+
+	// AutoUseManual adds the manual X/Y position to the "auto" candidates
+	AutoUseManual bool
+
+	// ExclusionZones are areas the watermark must never cover
+	ExclusionZones []PercentRect
+
+	// Jitter randomizes the mark per image to resist removal tools
+	Jitter JitterConfig
+
+	// Warp distorts the mark so template subtraction fails
+	Warp WarpConfig
+
+	// Knockout shows an altered photo inside the mark instead of a color
+	Knockout KnockoutConfig
+
+	// Redactions are applied to every image using this configuration
+	Redactions []Redaction
+
+	// Rights is written into the metadata of exported files
+	Rights RightsConfig
 }
 
 // AppData holds the main application state
@@ -43,6 +74,48 @@ type AppData struct {
 	OutputQuality int
 	Prefix        string
 	Suffix        string
+
+	// Redactions holds the regions drawn on individual images, keyed by path
+	Redactions map[string][]Redaction
+
+	// Crop is applied after redaction and before the watermark; CropBoxes
+	// holds the crop boxes placed manually on individual images
+	Crop      CropConfig
+	CropBoxes map[string]PercentRect
+
+	// Resize is applied after cropping and before the watermark
+	Resize ResizeConfig
+
+	// Adjust is applied after resizing
+	Adjust AdjustConfig
+
+	// MetadataPolicy decides which source metadata is carried to the output
+	MetadataPolicy MetadataPolicy
+
+	// ExifThumbnail is "regenerate" or "drop"
+	ExifThumbnail string
+
+	// Manifest produces one output per row with its values as text tokens
+	Manifest *Manifest
+
+	// Invisible hides the owner and image IDs in the exported pixels
+	Invisible InvisibleConfig
+
+	// Fragile signs every block of the exported pixels to detect edits
+	Fragile FragileConfig
+
+	// Fingerprint gives every export a traceable ID per recipient
+	Fingerprint FingerprintConfig
+
+	// Provenance is where the signed manifest goes: "off", "embed",
+	// "sidecar" or "both"
+	Provenance string
+
+	// TemplateName is the template last saved or loaded
+	TemplateName string
+
+	// Reversible stores the pixels under the visible mark for later removal
+	Reversible ReversibleConfig
 }
 
 var appData = &AppData{
@@ -56,11 +129,69 @@ var appData = &AppData{
 		Y:        10,
 		Rotation: 0,
 		IsImage:  false,
+		Type:     "text",
+		QR: QRConfig{
+			Level:     "M",
+			Color:     color.RGBA{R: 0, G: 0, B: 0, A: 255},
+			QuietZone: 4,
+			Size:      200,
+		},
+		Barcode: BarcodeConfig{
+			Symbology:   "Code 128",
+			ShowText:    true,
+			BarHeight:   80,
+			ModuleWidth: 2,
+			QuietZone:   10,
+		},
+		Jitter: JitterConfig{
+			Offset:   20,
+			Rotation: 3,
+			Scale:    5,
+			Opacity:  10,
+		},
+		Warp: WarpConfig{
+			Amplitude: 3,
+			Frequency: 2,
+		},
+		Knockout: KnockoutConfig{
+			Effect:   "blur",
+			Strength: 60,
+		},
 	},
 	OutputFormat:  "JPEG",
 	OutputQuality: 90,
 	Prefix:        "wm_",
 	Suffix:        "",
+	Crop: CropConfig{
+		Ratio:   "1:1",
+		CustomW: 3,
+		CustomH: 2,
+		Anchor:  "center",
+	},
+	Resize: ResizeConfig{
+		Mode:     "none",
+		Percent:  100,
+		Width:    1920,
+		Height:   1080,
+		LongEdge: 2048,
+		Filter:   "Lanczos",
+	},
+	Adjust: AdjustConfig{
+		Tone:             "none",
+		SharpenRadius:    1,
+		SharpenThreshold: 3,
+	},
+	MetadataPolicy: MetadataPolicy{
+		Name: "keep-all",
+	},
+	ExifThumbnail: "regenerate",
+	Invisible: InvisibleConfig{
+		Strength: 50,
+	},
+	Fingerprint: FingerprintConfig{
+		RecipientColumn: "recipient",
+	},
+	Provenance: "off",
 }
 
 // setWindowsUTF8 sets Windows console to UTF-8 mode
@@ -80,6 +211,11 @@ func main() {
 	// Set UTF-8 encoding for Windows
 	setWindowsUTF8()
 
+	// Command line tools run without the GUI
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	myApp := app.NewWithID("com.watermark.app")
 
 	myWindow := myApp.NewWindow("Watermark Tool")
@@ -117,6 +253,7 @@ func createMainUI(window fyne.Window) *container.Split {
 
 	imageList.OnSelected = func(id widget.ListItemID) {
 		appData.CurrentImage = id
+		imageSelected()
 		updatePreview()
 	}
 
@@ -148,19 +285,25 @@ func createMainUI(window fyne.Window) *container.Split {
 	basicTab := createBasicControls(window)
 	advancedTab := enhancedControls.CreateAdvancedControls()
 	positionTab := enhancedControls.CreatePositionControls()
+	redactionTab := enhancedControls.CreateRedactionControls()
 	outputTab := enhancedControls.CreateOutputControls()
+	protectionTab := enhancedControls.CreateProtectionControls()
 
 	// Wrap each tab in a scroll container
 	basicScroll := container.NewScroll(basicTab)
 	advancedScroll := container.NewScroll(advancedTab)
 	positionScroll := container.NewScroll(positionTab)
+	redactionScroll := container.NewScroll(redactionTab)
 	outputScroll := container.NewScroll(outputTab)
+	protectionScroll := container.NewScroll(protectionTab)
 
 	controlsTabs := container.NewAppTabs(
 		container.NewTabItem("Basic Settings", basicScroll),
 		container.NewTabItem("Advanced Settings", advancedScroll),
 		container.NewTabItem("Position Settings", positionScroll),
+		container.NewTabItem("Redaction", redactionScroll),
 		container.NewTabItem("Output Settings", outputScroll),
+		container.NewTabItem("Protection", protectionScroll),
 	)
 
 	// Main split container
@@ -179,8 +322,18 @@ func createMainUI(window fyne.Window) *container.Split {
 
 func createBasicControls(window fyne.Window) *fyne.Container {
 	// Watermark type selection
-	watermarkType := widget.NewRadioGroup([]string{"Text Watermark", "Image Watermark"}, func(value string) {
+	watermarkType := widget.NewRadioGroup([]string{"Text Watermark", "Image Watermark", "QR Code Watermark", "Barcode Watermark"}, func(value string) {
 		appData.Watermark.IsImage = value == "Image Watermark"
+		switch value {
+		case "Image Watermark":
+			appData.Watermark.Type = "image"
+		case "QR Code Watermark":
+			appData.Watermark.Type = "qr"
+		case "Barcode Watermark":
+			appData.Watermark.Type = "barcode"
+		default:
+			appData.Watermark.Type = "text"
+		}
 		updatePreview()
 	})
 	watermarkType.SetSelected("Text Watermark")
@@ -212,6 +365,7 @@ func createBasicControls(window fyne.Window) *fyne.Container {
 		"top-left", "top-center", "top-right",
 		"center-left", "center", "center-right",
 		"bottom-left", "bottom-center", "bottom-right",
+		"manual", "auto",
 	}, func(value string) {
 		appData.Watermark.Position = value
 		updatePreview()
@@ -261,6 +415,7 @@ func createBasicControls(window fyne.Window) *fyne.Container {
 
 		widget.NewLabel("Text Content:"),
 		textEntry,
+		widget.NewLabelWithStyle(tokenHelp, fyne.TextAlignLeading, fyne.TextStyle{Italic: true}),
 		widget.NewLabel("Font Size:"),
 		fontSizeSlider,
 		widget.NewLabel("Opacity:"),
@@ -275,6 +430,12 @@ func createBasicControls(window fyne.Window) *fyne.Container {
 		imageSelectBtn,
 		widget.NewSeparator(),
 
+		createQRControls(window),
+		widget.NewSeparator(),
+
+		createBarcodeControls(),
+		widget.NewSeparator(),
+
 		widget.NewLabel("Output Settings"),
 		widget.NewLabel("Format:"),
 		outputFormat,
@@ -306,6 +467,7 @@ func importSingleImage(window fyne.Window) {
 		path := reader.URI().Path()
 		if isValidImageFormat(path) {
 			appData.Images = append(appData.Images, path)
+			imageSelected()
 			updatePreview()
 		} else {
 			dialog.ShowError(errors.New("Unsupported format: Please select JPEG, PNG, BMP or TIFF images"), window)
@@ -335,6 +497,7 @@ func importFolder(window fyne.Window) {
 				appData.Images = append(appData.Images, file.Path())
 			}
 		}
+		imageSelected()
 		updatePreview()
 	}, window)
 }
@@ -354,6 +517,7 @@ func selectWatermarkImage(window fyne.Window) {
 		if isValidImageFormat(path) {
 			appData.Watermark.ImagePath = path
 			appData.Watermark.IsImage = true
+			appData.Watermark.Type = "image"
 			updatePreview()
 		} else {
 			dialog.ShowError(errors.New("Unsupported format: Please select a valid image format"), window)
@@ -366,9 +530,25 @@ func isValidImageFormat(filename string) bool {
 	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".bmp" || ext == ".tiff" || ext == ".tif"
 }
 
+// loadImage opens an image and applies its EXIF orientation so phone photos
+// are upright everywhere they are used
+func loadImage(path string) (image.Image, error) {
+	return imaging.Open(path, imaging.AutoOrientation(true))
+}
+
 // Global preview widget reference
 var globalPreviewWidget *PreviewWidget
 
+// imageSelectedCallbacks refresh controls showing per-image settings
+var imageSelectedCallbacks []func()
+
+// imageSelected is called when the current image changes
+func imageSelected() {
+	for _, callback := range imageSelectedCallbacks {
+		callback()
+	}
+}
+
 func updatePreview() {
 	// This function will be called when watermark settings change
 	if globalPreviewWidget != nil {
@@ -393,28 +573,105 @@ func exportImages(window fyne.Window) {
 
 		appData.OutputFolder = list.Path()
 
-		// Process each image
-		for i, imagePath := range appData.Images {
-			err := processImage(imagePath, i)
-			if err != nil {
-				dialog.ShowError(errors.New("Processing failed: "+err.Error()), window)
-				return
+		// Report manifest rows and images that don't match before starting
+		jobs, report := exportJobs()
+		if report == "" {
+			runExport(jobs, window)
+			return
+		}
+		dialog.ShowConfirm("Manifest Check", report+"\n\nExport "+strconv.Itoa(len(jobs))+" file(s) anyway?", func(ok bool) {
+			if ok {
+				runExport(jobs, window)
 			}
+		}, window)
+	}, window)
+}
+
+// runExport processes each export job and writes the export log
+func runExport(jobs []exportJob, window fyne.Window) {
+	// Nothing is written unless every file can go into the audit log
+	if err := checkAuditLog(); err != nil {
+		dialog.ShowError(errors.New("Audit log: "+err.Error()), window)
+		return
+	}
+
+	exportLog := NewExportLog()
+	registry := loadExportRegistry()
+	usedNames := make(map[string]bool)
+
+	for i, job := range jobs {
+		ctx := &ImageContext{Path: job.Path, Index: i, Total: len(jobs), usedNames: usedNames}
+		if job.Row != nil {
+			ctx.Row = job.Row.Number
+			ctx.Vars = job.Row.Vars
+		}
+		err := assignFingerprint(ctx, registry)
+		if err == nil {
+			err = processImage(ctx)
+		}
+		if err != nil {
+			exportLog.Save(appData.OutputFolder)
+			if invisibleMarkEnabled() {
+				registry.Save()
+			}
+			dialog.ShowError(errors.New("Processing failed: "+err.Error()), window)
+			return
+		}
+		exportLog.Add(ctx)
+		registry.Add(ctx)
+	}
+	if invisibleMarkEnabled() {
+		if err := registry.Save(); err != nil {
+			dialog.ShowError(errors.New("Failed to record exports for inspection: "+err.Error()), window)
 		}
+	}
+	message := "All images have been exported successfully."
+	if exportLog.warnings > 0 {
+		message = fmt.Sprintf("All images have been exported with %d warning(s).", exportLog.warnings)
+	}
 
-		dialog.ShowInformation("Complete", "All images have been exported successfully", window)
-	}, window)
+	if err := exportLog.Save(appData.OutputFolder); err != nil {
+		dialog.ShowError(errors.New("Failed to write export log: "+err.Error()), window)
+		return
+	}
+
+	dialog.ShowInformation("Complete", message+"\nDetails were written to "+exportLogName, window)
 }
 
-func processImage(inputPath string, index int) error {
-	// Load the original image
-	img, err := imaging.Open(inputPath)
+func processImage(ctx *ImageContext) error {
+	inputPath := ctx.Path
+
+	// Load the original image upright. The output's orientation tag is reset,
+	// so viewers won't rotate it a second time.
+	img, err := loadImage(inputPath)
 	if err != nil {
 		return err
 	}
 
+	// Hide redacted areas before any watermark layer is drawn
+	img = applyRedactions(img, ctx)
+
+	// Crop and resize so the watermark is positioned and rendered at the final size
+	img = applyCrop(img, ctx)
+	img = applyResize(img, ctx)
+
+	// Sharpen and tone the resized image
+	img = applyAdjustments(img, ctx)
+
 	// Apply watermark
-	watermarkedImg := applyWatermark(img)
+	watermarkedImg := applyWatermark(img, ctx)
+
+	// Hide the owner and image IDs in the final pixels
+	watermarkedImg = applyInvisibleMark(watermarkedImg, ctx)
+
+	// Sign the pixels last, any later change would break the fragile mark
+	watermarkedImg = applyFragileMark(watermarkedImg, ctx)
+
+	// Keep every pixel the marks changed, encrypted, for reversible exports
+	restore, err := captureReversible(img, watermarkedImg, ctx)
+	if err != nil {
+		return err
+	}
 
 	// Generate output filename
 	baseName := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
@@ -422,21 +679,90 @@ func processImage(inputPath string, index int) error {
 	if appData.OutputFormat == "PNG" {
 		ext = ".png"
 	}
-	outputName := appData.Prefix + baseName + appData.Suffix + ext
+	prefix := sanitizeFileName(expandTokens(appData.Prefix, ctx))
+	suffix := sanitizeFileName(expandTokens(appData.Suffix, ctx))
+	outputName := uniqueOutputName(prefix+baseName+suffix+ext, ctx)
 	outputPath := filepath.Join(appData.OutputFolder, outputName)
+	ctx.OutputPath = outputPath
+
+	// Carry EXIF, XMP and ICC data over, updated for the processed pixels
+	md, err := readMetadata(inputPath)
+	if err != nil {
+		ctx.Logf("metadata not read: %v", err)
+		md = nil
+	} else {
+		bounds := watermarkedImg.Bounds()
+		md.Normalize(bounds.Dx(), bounds.Dy(), ctx)
+		applyMetadataPolicy(md, ctx)
+		applyRights(md, ctx)
+		applyThumbnail(md, watermarkedImg, ctx)
+	}
+
+	// Sign what the written file will decode to
+	var provenance []byte
+	if appData.Provenance != "" && appData.Provenance != "off" {
+		if provenance, err = createProvenance(watermarkedImg, ctx); err != nil {
+			return fmt.Errorf("provenance: %v", err)
+		}
+		if appData.Provenance != "sidecar" {
+			if md == nil {
+				md = &Metadata{}
+			}
+			md.Provenance = provenance
+		}
+	}
+
+	if len(restore) > 0 && !appData.Reversible.Sidecar {
+		if md == nil {
+			md = &Metadata{}
+		}
+		md.Restore = restore
+	}
+
+	if md != nil && appData.OutputFormat != "PNG" {
+		md.fitJPEG(ctx)
+	}
+
+	if err := checkAuditLog(); err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
 
 	// Save the image
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
-	defer outputFile.Close()
+	err = encodeImage(outputFile, watermarkedImg, appData.OutputFormat, appData.OutputQuality, md)
+	embedded := md != nil
+	if err != nil && md != nil {
+		ctx.Warnf("metadata not embedded: %v", err)
+		embedded = false
+		err = encodeImage(outputFile, watermarkedImg, appData.OutputFormat, appData.OutputQuality, nil)
+	}
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
 
-	if appData.OutputFormat == "PNG" {
-		err = png.Encode(outputFile, watermarkedImg)
-	} else {
-		err = jpeg.Encode(outputFile, watermarkedImg, &jpeg.Options{Quality: appData.OutputQuality})
+	if err := saveProvenanceSidecar(outputPath, provenance, embedded && len(md.Provenance) > 0, ctx); err != nil {
+		return err
+	}
+	if err := saveReversibleSidecar(outputPath, restore, embedded && len(md.Restore) > 0, ctx); err != nil {
+		return err
+	}
+
+	// Every written file goes into the hash-chained audit log, files that
+	// couldn't be recorded are removed
+	if err := appendAuditEntry(ctx); err != nil {
+		for _, path := range append([]string{outputPath}, ctx.Sidecars...) {
+			os.Remove(path)
+		}
+		return fmt.Errorf("audit log: %v", err)
 	}
 
-	return err
+	// Make sure the embedded preview doesn't show anything the image doesn't
+	verifyThumbnail(outputPath, ctx)
+	return nil
 }
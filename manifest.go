@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// manifestFileColumns are the column names that tie a row to an input image.
+// Without one, every row applies to every image.
+var manifestFileColumns = []string{"file", "filename", "input", "image"}
+
+// Manifest maps input files or recipients to token values for mail merge
+type Manifest struct {
+	Path       string
+	Columns    []string
+	FileColumn string // empty when rows are recipients for every image
+	Rows       []ManifestRow
+}
+
+// ManifestRow is one output to produce
+type ManifestRow struct {
+	Number int // 1-based row number, used in logs and to resolve name collisions
+	Vars   map[string]string
+}
+
+// exportJob is one output of a batch export
+type exportJob struct {
+	Path string
+	Row  *ManifestRow
+}
+
+// loadManifest reads a CSV file with a header row, or a JSON array of objects
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Path: path}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var records []map[string]interface{}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("manifest: %v", err)
+		}
+		seen := map[string]bool{}
+		for i, record := range records {
+			row := ManifestRow{Number: i + 1, Vars: map[string]string{}}
+			for key, value := range record {
+				if value != nil {
+					row.Vars[key] = fmt.Sprint(value)
+				}
+				if !seen[key] {
+					seen[key] = true
+					m.Columns = append(m.Columns, key)
+				}
+			}
+			m.Rows = append(m.Rows, row)
+		}
+		sort.Strings(m.Columns)
+	} else {
+		reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\uFEFF")))
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %v", err)
+		}
+		if len(records) == 0 {
+			return nil, errors.New("manifest: missing header row")
+		}
+		for _, column := range records[0] {
+			m.Columns = append(m.Columns, strings.TrimSpace(column))
+		}
+		for i, record := range records[1:] {
+			row := ManifestRow{Number: i + 1, Vars: map[string]string{}}
+			for j, value := range record {
+				if j < len(m.Columns) && m.Columns[j] != "" {
+					row.Vars[m.Columns[j]] = strings.TrimSpace(value)
+				}
+			}
+			m.Rows = append(m.Rows, row)
+		}
+	}
+
+	if len(m.Rows) == 0 {
+		return nil, errors.New("manifest: no rows")
+	}
+	for _, column := range m.Columns {
+		for _, name := range manifestFileColumns {
+			if strings.EqualFold(column, name) && m.FileColumn == "" {
+				m.FileColumn = column
+			}
+		}
+	}
+	return m, nil
+}
+
+// matchesImage reports whether a row's file value refers to the image path
+func matchesImage(value, path string) bool {
+	if value == "" {
+		return false
+	}
+	if value == path {
+		return true
+	}
+	base := filepath.Base(path)
+	value = filepath.Base(value)
+	return strings.EqualFold(value, base) ||
+		strings.EqualFold(value, strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// jobs expands the images into one export job per manifest row. It also
+// returns the images without a row and the rows without an image.
+func (m *Manifest) jobs(images []string) ([]exportJob, []string, []*ManifestRow) {
+	var jobs []exportJob
+	var missing []string
+	var extra []*ManifestRow
+
+	if m.FileColumn == "" {
+		for _, path := range images {
+			for i := range m.Rows {
+				jobs = append(jobs, exportJob{Path: path, Row: &m.Rows[i]})
+			}
+		}
+		return jobs, nil, nil
+	}
+
+	used := make([]bool, len(m.Rows))
+	for _, path := range images {
+		found := false
+		for i := range m.Rows {
+			if matchesImage(m.Rows[i].Vars[m.FileColumn], path) {
+				jobs = append(jobs, exportJob{Path: path, Row: &m.Rows[i]})
+				used[i] = true
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, path)
+		}
+	}
+	for i := range m.Rows {
+		if !used[i] {
+			extra = append(extra, &m.Rows[i])
+		}
+	}
+	return jobs, missing, extra
+}
+
+// previewRow returns the first row used for the image, for the preview
+func (m *Manifest) previewRow(path string) *ManifestRow {
+	for i := range m.Rows {
+		if m.FileColumn == "" || matchesImage(m.Rows[i].Vars[m.FileColumn], path) {
+			return &m.Rows[i]
+		}
+	}
+	return nil
+}
+
+// exportJobs lists the outputs to produce and describes any mismatch between
+// the manifest and the imported images
+func exportJobs() ([]exportJob, string) {
+	if appData.Manifest == nil {
+		jobs := make([]exportJob, len(appData.Images))
+		for i, path := range appData.Images {
+			jobs[i] = exportJob{Path: path}
+		}
+		return jobs, ""
+	}
+
+	jobs, missing, extra := appData.Manifest.jobs(appData.Images)
+	var report []string
+	if len(missing) > 0 {
+		report = append(report, fmt.Sprintf("%d image(s) have no manifest row and will be skipped:", len(missing)))
+		for i, path := range missing {
+			if i == 5 {
+				report = append(report, fmt.Sprintf("  ... and %d more", len(missing)-5))
+				break
+			}
+			report = append(report, "  "+filepath.Base(path))
+		}
+	}
+	if len(extra) > 0 {
+		report = append(report, fmt.Sprintf("%d manifest row(s) match no imported image:", len(extra)))
+		for i, row := range extra {
+			if i == 5 {
+				report = append(report, fmt.Sprintf("  ... and %d more", len(extra)-5))
+				break
+			}
+			report = append(report, fmt.Sprintf("  row %d: %s", row.Number, row.Vars[appData.Manifest.FileColumn]))
+		}
+	}
+	return jobs, strings.Join(report, "\n")
+}
+
+// uniqueOutputName appends the manifest row number, or a counter, when an
+// output name was already used in this export
+func uniqueOutputName(name string, ctx *ImageContext) string {
+	if ctx.usedNames == nil {
+		return name
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 1; ctx.usedNames[strings.ToLower(candidate)]; n++ {
+		switch {
+		case ctx.Row > 0 && n == 1:
+			candidate = fmt.Sprintf("%s_%d%s", base, ctx.Row, ext)
+		default:
+			candidate = fmt.Sprintf("%s_%d%s", base, n, ext)
+		}
+	}
+	if candidate != name {
+		ctx.Logf("output name %s already used, wrote %s", name, candidate)
+	}
+	ctx.usedNames[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// sanitizeFileName replaces characters that aren't allowed in file names
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// createManifestControls creates the mail merge manifest settings
+func createManifestControls(window fyne.Window) *fyne.Container {
+	statusLabel := widget.NewLabel("")
+	updateStatus := func() {
+		m := appData.Manifest
+		if m == nil {
+			statusLabel.SetText("No manifest loaded")
+			return
+		}
+		mode := "every row applies to every image"
+		if m.FileColumn != "" {
+			mode = "rows matched by \"" + m.FileColumn + "\" column"
+		}
+		statusLabel.SetText(fmt.Sprintf("%s: %d rows, %s\nColumns: {%s}",
+			filepath.Base(m.Path), len(m.Rows), mode, strings.Join(m.Columns, "} {")))
+	}
+	updateStatus()
+
+	loadBtn := widget.NewButton("Load CSV/JSON", func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			m, err := loadManifest(reader.URI().Path())
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			appData.Manifest = m
+			updateStatus()
+			updatePreview()
+		}, window)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv", ".json"}))
+		fileDialog.Show()
+	})
+
+	clearBtn := widget.NewButton("Clear", func() {
+		appData.Manifest = nil
+		updateStatus()
+		updatePreview()
+	})
+
+	return container.NewVBox(
+		container.NewGridWithColumns(2, loadBtn, clearBtn),
+		statusLabel,
+		widget.NewLabel("Columns are used as {column} tokens in the text, QR code, prefix and suffix"),
+	)
+}
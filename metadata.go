@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Markers and signatures of the metadata containers
+const (
+	jpegExifHeader = "Exif\x00\x00"
+	jpegXMPHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegICCHeader  = "ICC_PROFILE\x00"
+	pngSignature   = "\x89PNG\r\n\x1a\n"
+	pngXMPKeyword  = "XML:com.adobe.xmp"
+
+	// The signed provenance manifest goes in an APP11 segment or a private,
+	// unsafe-to-copy PNG chunk
+	jpegProvenanceHeader = "WMProvenance\x00"
+	pngProvenanceChunk   = "wmPV"
+
+	// Restore data of reversible exports is split over numbered APP11
+	// segments in JPEG files
+	jpegRestoreHeader = "WMRestore\x00"
+	pngRestoreChunk   = "wmRV"
+
+	jpegMaxSegment = 65533 // largest segment payload after the length field
+	jpegMaxXMP     = jpegMaxSegment - len(jpegXMPHeader)
+)
+
+// Metadata is the EXIF, XMP and ICC data carried from a source image to its
+// output, independent of the container format
+type Metadata struct {
+	Exif []byte // TIFF structure without the JPEG "Exif" header
+	XMP  []byte
+	ICC  []byte
+	Text []pngText // PNG text chunks other than XMP
+
+	Provenance []byte // signed provenance manifest
+	Restore    []byte // encrypted pixels under the visible mark
+}
+
+// pngText is a PNG tEXt, zTXt or iTXt entry
+type pngText struct {
+	Keyword string
+	Text    string
+}
+
+// readMetadata reads the metadata of a JPEG or PNG file. Other formats
+// return empty metadata.
+func readMetadata(path string) (*Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return readJPEGMetadata(data)
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return readPNGMetadata(data)
+	}
+	return &Metadata{}, nil
+}
+
+// readJPEGMetadata collects the APP1 Exif/XMP and APP2 ICC segments
+func readJPEGMetadata(data []byte) (*Metadata, error) {
+	md := &Metadata{}
+	iccChunks := map[int][]byte{}
+	var restoreChunks [][]byte
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, errors.New("jpeg: invalid marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++ // fill byte
+			continue
+		}
+		if marker == 0xD9 || marker == 0xDA {
+			break // metadata precedes the scan data
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("jpeg: truncated segment")
+		}
+		payload := data[i+4 : i+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte(jpegExifHeader)) && md.Exif == nil:
+			md.Exif = append([]byte(nil), payload[len(jpegExifHeader):]...)
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte(jpegXMPHeader)) && md.XMP == nil:
+			md.XMP = append([]byte(nil), payload[len(jpegXMPHeader):]...)
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte(jpegICCHeader)) && len(payload) > len(jpegICCHeader)+2:
+			seq := int(payload[len(jpegICCHeader)])
+			iccChunks[seq] = payload[len(jpegICCHeader)+2:]
+		case marker == 0xEB && bytes.HasPrefix(payload, []byte(jpegProvenanceHeader)):
+			md.Provenance = append([]byte(nil), payload[len(jpegProvenanceHeader):]...)
+		case marker == 0xEB && bytes.HasPrefix(payload, []byte(jpegRestoreHeader)) && len(payload) >= len(jpegRestoreHeader)+4:
+			seq := int(binary.BigEndian.Uint16(payload[len(jpegRestoreHeader):]))
+			count := int(binary.BigEndian.Uint16(payload[len(jpegRestoreHeader)+2:]))
+			if restoreChunks == nil {
+				restoreChunks = make([][]byte, count)
+			}
+			if seq < len(restoreChunks) {
+				restoreChunks[seq] = payload[len(jpegRestoreHeader)+4:]
+			}
+		}
+
+		i += 2 + length
+	}
+
+	// ICC profiles larger than one segment are split into numbered chunks
+	if len(iccChunks) > 0 {
+		var seqs []int
+		for seq := range iccChunks {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			md.ICC = append(md.ICC, iccChunks[seq]...)
+		}
+	}
+
+	// Restore data is only usable when every segment is present
+	complete := len(restoreChunks) > 0
+	for _, chunk := range restoreChunks {
+		complete = complete && chunk != nil
+	}
+	if complete {
+		md.Restore = bytes.Join(restoreChunks, nil)
+	}
+
+	return md, nil
+}
+
+// readPNGMetadata collects the eXIf, iCCP and text chunks
+func readPNGMetadata(data []byte) (*Metadata, error) {
+	md := &Metadata{}
+
+	for i := len(pngSignature); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		chunkType := string(data[i+4 : i+8])
+		payload := data[i+8 : i+8+length]
+		i += 12 + length
+
+		switch chunkType {
+		case "eXIf":
+			md.Exif = append([]byte(nil), payload...)
+		case "iCCP":
+			// profile name, NUL, compression method, zlib stream
+			nul := bytes.IndexByte(payload, 0)
+			if nul < 0 || nul+2 > len(payload) {
+				continue
+			}
+			if icc, err := inflate(payload[nul+2:]); err == nil {
+				md.ICC = icc
+			}
+		case "tEXt":
+			if keyword, text, ok := bytes.Cut(payload, []byte{0}); ok {
+				md.Text = append(md.Text, pngText{string(keyword), latin1ToUTF8(text)})
+			}
+		case "zTXt":
+			keyword, rest, ok := bytes.Cut(payload, []byte{0})
+			if !ok || len(rest) < 1 {
+				continue
+			}
+			if text, err := inflate(rest[1:]); err == nil {
+				md.Text = append(md.Text, pngText{string(keyword), latin1ToUTF8(text)})
+			}
+		case "iTXt":
+			keyword, text, ok := parseITXt(payload)
+			if !ok {
+				continue
+			}
+			if keyword == pngXMPKeyword {
+				md.XMP = []byte(text)
+			} else {
+				md.Text = append(md.Text, pngText{keyword, text})
+			}
+		case pngProvenanceChunk:
+			md.Provenance = append([]byte(nil), payload...)
+		case pngRestoreChunk:
+			md.Restore = append([]byte(nil), payload...)
+		case "IEND":
+			return md, nil
+		}
+	}
+
+	return md, nil
+}
+
+// parseITXt decodes an iTXt chunk payload
+func parseITXt(payload []byte) (string, string, bool) {
+	keyword, rest, ok := bytes.Cut(payload, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", "", false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	// Skip the language tag and translated keyword
+	for n := 0; n < 2; n++ {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return "", "", false
+		}
+	}
+	if compressed {
+		text, err := inflate(rest)
+		if err != nil {
+			return "", "", false
+		}
+		rest = text
+	}
+	return string(keyword), string(rest), true
+}
+
+// inflate decompresses a zlib stream
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// latin1ToUTF8 converts tEXt/zTXt text, which PNG defines as Latin-1
+func latin1ToUTF8(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// xmpOrientation and xmpDimension match the XMP properties that describe
+// the pixel layout, in both attribute and element form
+var (
+	xmpOrientation = regexp.MustCompile(`(tiff:Orientation(?:="|>))\d+`)
+	xmpDimension   = regexp.MustCompile(`((?:exif|tiff):(?:PixelXDimension|PixelYDimension|ImageWidth|ImageLength)(?:="|>))\d+`)
+)
+
+// Normalize updates the metadata for the processed image: the pixels are
+// upright and the dimensions are those of the output
+func (md *Metadata) Normalize(width, height int, ctx *ImageContext) {
+	// The source's manifest and restore data don't describe the new pixels
+	md.Provenance, md.Restore = nil, nil
+
+	if len(md.Exif) > 0 {
+		exif, err := parseExif(md.Exif)
+		if err != nil {
+			ctx.Logf("dropped unreadable EXIF: %v", err)
+			md.Exif = nil
+		} else {
+			exif.normalize(width, height)
+			if exif.dropMakerNote() {
+				ctx.Warnf("dropped the camera MakerNote, its offsets would be wrong in the rewritten EXIF")
+			}
+			md.Exif = exif.encode()
+		}
+	}
+
+	if len(md.XMP) > 0 {
+		md.XMP = xmpOrientation.ReplaceAll(md.XMP, []byte("${1}1"))
+		md.XMP = xmpDimension.ReplaceAllFunc(md.XMP, func(match []byte) []byte {
+			sub := xmpDimension.FindSubmatch(match)
+			value := width
+			if bytes.Contains(sub[1], []byte("YDimension")) || bytes.Contains(sub[1], []byte("ImageLength")) {
+				value = height
+			}
+			return append(append([]byte(nil), sub[1]...), strconv.Itoa(value)...)
+		})
+	}
+}
+
+// fitJPEG drops XMP too large for the single APP1 segment it is written
+// to, so the rest of the metadata can still be embedded
+func (md *Metadata) fitJPEG(ctx *ImageContext) {
+	if len(md.XMP) > jpegMaxXMP {
+		ctx.Warnf("XMP left out, %d bytes is more than a JPEG segment holds", len(md.XMP))
+		md.XMP = nil
+	}
+}
+
+// encodeImage encodes img as JPEG or PNG and embeds the metadata
+func encodeImage(w io.Writer, img image.Image, format string, quality int, md *Metadata) error {
+	var buf bytes.Buffer
+	var err error
+	if format == "PNG" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if md != nil {
+		if format == "PNG" {
+			data, err = embedPNGMetadata(data, md)
+		} else {
+			data, err = embedJPEGMetadata(data, md)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// embedJPEGMetadata inserts APP1 and APP2 segments directly after SOI
+func embedJPEGMetadata(data []byte, md *Metadata) ([]byte, error) {
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, parts ...[]byte) error {
+		length := 2
+		for _, part := range parts {
+			length += len(part)
+		}
+		if length-2 > jpegMaxSegment {
+			return fmt.Errorf("jpeg: metadata segment too large (%d bytes)", length)
+		}
+		segments.Write([]byte{0xFF, marker, byte(length >> 8), byte(length)})
+		for _, part := range parts {
+			segments.Write(part)
+		}
+		return nil
+	}
+
+	if len(md.Exif) > 0 {
+		if err := writeSegment(0xE1, []byte(jpegExifHeader), md.Exif); err != nil {
+			return nil, err
+		}
+	}
+	if len(md.XMP) > 0 {
+		if err := writeSegment(0xE1, []byte(jpegXMPHeader), md.XMP); err != nil {
+			return nil, err
+		}
+	}
+	if len(md.ICC) > 0 {
+		chunkSize := jpegMaxSegment - len(jpegICCHeader) - 2
+		count := (len(md.ICC) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, errors.New("jpeg: ICC profile too large")
+		}
+		for seq := 0; seq < count; seq++ {
+			chunk := md.ICC[seq*chunkSize : min(len(md.ICC), (seq+1)*chunkSize)]
+			if err := writeSegment(0xE2, []byte(jpegICCHeader), []byte{byte(seq + 1), byte(count)}, chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(md.Provenance) > 0 {
+		if err := writeSegment(0xEB, []byte(jpegProvenanceHeader), md.Provenance); err != nil {
+			return nil, err
+		}
+	}
+	if len(md.Restore) > 0 {
+		chunkSize := jpegMaxSegment - len(jpegRestoreHeader) - 4
+		count := (len(md.Restore) + chunkSize - 1) / chunkSize
+		if count > 0xFFFF {
+			return nil, errors.New("jpeg: restore data too large")
+		}
+		for seq := 0; seq < count; seq++ {
+			chunk := md.Restore[seq*chunkSize : min(len(md.Restore), (seq+1)*chunkSize)]
+			numbers := []byte{byte(seq >> 8), byte(seq), byte(count >> 8), byte(count)}
+			if err := writeSegment(0xEB, []byte(jpegRestoreHeader), numbers, chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(data)+segments.Len())
+	out = append(out, data[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, data[2:]...), nil
+}
+
+// embedPNGMetadata inserts iCCP, eXIf and iTXt chunks directly after IHDR
+func embedPNGMetadata(data []byte, md *Metadata) ([]byte, error) {
+	ihdrEnd := len(pngSignature) + 12 + 13
+	if len(data) < ihdrEnd || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, errors.New("png: missing IHDR")
+	}
+
+	var chunks bytes.Buffer
+	if len(md.ICC) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(md.ICC)
+		zw.Close()
+		writePNGChunk(&chunks, "iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if len(md.Exif) > 0 {
+		writePNGChunk(&chunks, "eXIf", md.Exif)
+	}
+	if len(md.XMP) > 0 {
+		writePNGChunk(&chunks, "iTXt", iTXtPayload(pngXMPKeyword, string(md.XMP)))
+	}
+	for _, text := range md.Text {
+		writePNGChunk(&chunks, "iTXt", iTXtPayload(text.Keyword, text.Text))
+	}
+	if len(md.Provenance) > 0 {
+		writePNGChunk(&chunks, pngProvenanceChunk, md.Provenance)
+	}
+	if len(md.Restore) > 0 {
+		writePNGChunk(&chunks, pngRestoreChunk, md.Restore)
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// iTXtPayload builds an uncompressed iTXt chunk payload
+func iTXtPayload(keyword, text string) []byte {
+	payload := append([]byte(keyword), 0, 0, 0) // NUL, no compression, method 0
+	payload = append(payload, 0, 0)             // empty language tag and translated keyword
+	return append(payload, text...)
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(w *bytes.Buffer, chunkType string, payload []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	copy(header[4:], chunkType)
+	w.Write(header[:])
+	w.Write(payload)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(payload)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
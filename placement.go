@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// gridPositions lists the nine preset anchor positions
+var gridPositions = []string{
+	"top-left", "top-center", "top-right",
+	"center-left", "center", "center-right",
+	"bottom-left", "bottom-center", "bottom-right",
+}
+
+// resolvePosition returns the concrete position for the watermark layer,
+// choosing the calmest candidate when the position is "auto" and moving the
+// layer out of exclusion zones. An empty result means the layer is skipped.
+func resolvePosition(img *image.RGBA, layerBounds image.Rectangle, ctx *ImageContext) string {
+	position := appData.Watermark.Position
+	if position == "auto" {
+		return chooseCalmestPosition(img, layerBounds, ctx)
+	}
+
+	origin := layerRect(img.Bounds(), layerBounds, position)
+	if !overlapsExclusionZone(img.Bounds(), origin) {
+		return position
+	}
+
+	// Move to the nearest grid cell that stays clear of every zone
+	best := ""
+	bestDistance := math.MaxFloat64
+	for _, candidate := range gridPositions {
+		rect := layerRect(img.Bounds(), layerBounds, candidate)
+		if overlapsExclusionZone(img.Bounds(), rect) {
+			continue
+		}
+		dx := float64(rect.Min.X - origin.Min.X)
+		dy := float64(rect.Min.Y - origin.Min.Y)
+		if distance := math.Hypot(dx, dy); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	if best == "" {
+		ctx.Logf("watermark skipped: %s overlaps an exclusion zone and no clear position exists", position)
+		return ""
+	}
+	ctx.Logf("moved from %s to %s to avoid an exclusion zone", position, best)
+	return best
+}
+
+// chooseCalmestPosition scores every candidate clear of the exclusion zones
+// and returns the one over the calmest part of the photo
+func chooseCalmestPosition(img *image.RGBA, layerBounds image.Rectangle, ctx *ImageContext) string {
+	candidates := append([]string{}, gridPositions...)
+	if appData.Watermark.AutoUseManual {
+		candidates = append(candidates, "manual")
+	}
+
+	best := ""
+	bestScore := math.MaxFloat64
+	for _, candidate := range candidates {
+		rect := layerRect(img.Bounds(), layerBounds, candidate)
+		if overlapsExclusionZone(img.Bounds(), rect) {
+			continue
+		}
+		if score := regionBusyness(img, rect); best == "" || score < bestScore {
+			best = candidate
+			bestScore = score
+		}
+	}
+
+	if best == "" {
+		ctx.Logf("watermark skipped: every auto candidate overlaps an exclusion zone")
+		return ""
+	}
+	ctx.Logf("auto position %s (busyness %.1f)", best, bestScore)
+	return best
+}
+
+// layerRect returns the rectangle covered by the layer at the given position
+func layerRect(imgBounds, layerBounds image.Rectangle, position string) image.Rectangle {
+	x, y := calculateImagePosition(imgBounds, layerBounds, position)
+	return image.Rect(x, y, x+layerBounds.Dx(), y+layerBounds.Dy())
+}
+
+// regionBusyness measures the edge energy and luminance spread of the photo
+// under rect. Lower values mean a calmer region.
+func regionBusyness(img *image.RGBA, rect image.Rectangle) float64 {
+	rect = rect.Intersect(img.Bounds())
+	if rect.Empty() {
+		return math.MaxFloat64
+	}
+
+	// Sample at most ~64 points per side to keep large photos fast
+	step := max(1, max(rect.Dx(), rect.Dy())/64)
+
+	var sum, sumSq, edges float64
+	count := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y += step {
+		for x := rect.Min.X; x < rect.Max.X; x += step {
+			l := luminanceAt(img, x, y)
+			if x+step < rect.Max.X {
+				edges += math.Abs(luminanceAt(img, x+step, y) - l)
+			}
+			if y+step < rect.Max.Y {
+				edges += math.Abs(luminanceAt(img, x, y+step) - l)
+			}
+			sum += l
+			sumSq += l * l
+			count++
+		}
+	}
+
+	mean := sum / float64(count)
+	variance := math.Max(sumSq/float64(count)-mean*mean, 0)
+	return edges/float64(count) + math.Sqrt(variance)
+}
+
+// luminanceAt returns the Rec. 601 luma of a pixel
+func luminanceAt(img *image.RGBA, x, y int) float64 {
+	i := img.PixOffset(x, y)
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// OutputPreset holds a reusable set of output settings
+type OutputPreset struct {
+	OutputFormat  string
+	OutputQuality int
+	Prefix        string
+	Suffix        string
+	Crop          CropConfig
+	Resize        ResizeConfig
+	Adjust        AdjustConfig
+	Metadata      MetadataPolicy
+	ExifThumbnail string
+}
+
+// PresetManager handles saving and loading output presets
+type PresetManager struct {
+	presets map[string]*OutputPreset
+	window  fyne.Window
+}
+
+// NewPresetManager creates a new preset manager
+func NewPresetManager(window fyne.Window) *PresetManager {
+	pm := &PresetManager{
+		presets: make(map[string]*OutputPreset),
+		window:  window,
+	}
+	pm.loadPresets()
+	return pm
+}
+
+// SavePreset saves the current output settings as a preset
+func (pm *PresetManager) SavePreset() {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("Enter preset name")
+
+	dialog.ShowCustomConfirm("Save Output Preset", "OK", "Cancel", entry, func(save bool) {
+		if !save {
+			return
+		}
+
+		name := entry.Text
+		if name == "" {
+			dialog.ShowError(errors.New("Error: Preset name cannot be empty"), pm.window)
+			return
+		}
+
+		pm.presets[name] = &OutputPreset{
+			OutputFormat:  appData.OutputFormat,
+			OutputQuality: appData.OutputQuality,
+			Prefix:        appData.Prefix,
+			Suffix:        appData.Suffix,
+			Crop:          appData.Crop,
+			Resize:        appData.Resize,
+			Adjust:        appData.Adjust,
+			Metadata: MetadataPolicy{
+				Name:  appData.MetadataPolicy.Name,
+				Allow: append([]string(nil), appData.MetadataPolicy.Allow...),
+				Deny:  append([]string(nil), appData.MetadataPolicy.Deny...),
+			},
+			ExifThumbnail: appData.ExifThumbnail,
+		}
+		pm.savePresetsToFile()
+
+		dialog.ShowInformation("Success", "Output preset saved", pm.window)
+	}, pm.window)
+}
+
+// LoadPreset applies a saved preset
+func (pm *PresetManager) LoadPreset() {
+	if len(pm.presets) == 0 {
+		dialog.ShowInformation("Info", "No saved output presets", pm.window)
+		return
+	}
+
+	var names []string
+	for name := range pm.presets {
+		names = append(names, name)
+	}
+
+	selectWidget := widget.NewSelect(names, nil)
+	selectWidget.PlaceHolder = "Select Preset"
+
+	dialog.ShowCustomConfirm("Load Output Preset", "Load", "Cancel", selectWidget, func(load bool) {
+		if !load || selectWidget.Selected == "" {
+			return
+		}
+
+		preset, exists := pm.presets[selectWidget.Selected]
+		if !exists {
+			dialog.ShowError(errors.New("Error: Preset not found"), pm.window)
+			return
+		}
+
+		appData.OutputFormat = preset.OutputFormat
+		appData.OutputQuality = preset.OutputQuality
+		appData.Prefix = preset.Prefix
+		appData.Suffix = preset.Suffix
+		appData.Crop = preset.Crop
+		appData.Resize = preset.Resize
+		appData.Adjust = preset.Adjust
+		if preset.Metadata.Name != "" {
+			appData.MetadataPolicy = preset.Metadata
+		}
+		if preset.ExifThumbnail != "" {
+			appData.ExifThumbnail = preset.ExifThumbnail
+		}
+		updatePreview()
+
+		dialog.ShowInformation("Success", "Output preset loaded", pm.window)
+	}, pm.window)
+}
+
+// DeletePreset deletes a saved preset
+func (pm *PresetManager) DeletePreset() {
+	if len(pm.presets) == 0 {
+		dialog.ShowInformation("Info", "No saved output presets", pm.window)
+		return
+	}
+
+	var names []string
+	for name := range pm.presets {
+		names = append(names, name)
+	}
+
+	selectWidget := widget.NewSelect(names, nil)
+	selectWidget.PlaceHolder = "Select preset to delete"
+
+	dialog.ShowCustomConfirm("Delete Output Preset", "Delete", "Cancel", selectWidget, func(shouldDelete bool) {
+		if !shouldDelete || selectWidget.Selected == "" {
+			return
+		}
+
+		delete(pm.presets, selectWidget.Selected)
+		pm.savePresetsToFile()
+
+		dialog.ShowInformation("Success", "Output preset deleted", pm.window)
+	}, pm.window)
+}
+
+// presetsFile returns the path of the presets JSON file
+func presetsFile() string {
+	userDataDir := fyne.CurrentApp().Storage().RootURI().Path()
+	return filepath.Join(userDataDir, "output_presets.json")
+}
+
+// savePresetsToFile saves presets to a JSON file
+func (pm *PresetManager) savePresetsToFile() {
+	file := presetsFile()
+	os.MkdirAll(filepath.Dir(file), 0755)
+
+	data, err := json.MarshalIndent(pm.presets, "", "  ")
+	if err != nil {
+		return
+	}
+	os.WriteFile(file, data, 0644)
+}
+
+// loadPresets loads presets from a JSON file
+func (pm *PresetManager) loadPresets() {
+	data, err := os.ReadFile(presetsFile())
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &pm.presets)
+}
@@ -6,6 +6,7 @@ import (
 	"image/color"
 	"image/draw"
 	"image/png"
+	"math"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -22,6 +23,11 @@ type PreviewWidget struct {
 	container *fyne.Container
 	imageCard *widget.Card
 	imageObj  *canvas.Image
+	selector  *RegionSelector
+
+	// showSource shows the photo before cropping and watermarking while a
+	// region on the original photo is being drawn
+	showSource bool
 }
 
 // NewPreviewWidget creates a new preview widget
@@ -30,7 +36,10 @@ func NewPreviewWidget() *PreviewWidget {
 	imageObj.FillMode = canvas.ImageFillContain
 	imageObj.SetMinSize(fyne.NewSize(300, 200)) // Set minimum size for preview
 
-	imageCard := widget.NewCard("Preview", "No image selected", imageObj)
+	// Region selector overlay for drawing zones on the preview
+	selector := NewRegionSelector()
+
+	imageCard := widget.NewCard("Preview", "No image selected", container.NewStack(imageObj, selector))
 	// Remove fixed size to allow flexible resizing
 
 	container := container.NewVBox(imageCard)
@@ -39,9 +48,36 @@ func NewPreviewWidget() *PreviewWidget {
 		container: container,
 		imageCard: imageCard,
 		imageObj:  imageObj,
+		selector:  selector,
 	}
 }
 
+// SelectRegion lets the user drag a rectangle on the watermarked preview and
+// reports it in percent
+func (pw *PreviewWidget) SelectRegion(onSelected func(PercentRect)) {
+	pw.selectRegion(false, onSelected)
+}
+
+// SelectSourceRegion is like SelectRegion but shows the original photo, for
+// regions applied before cropping such as redactions and the crop box
+func (pw *PreviewWidget) SelectSourceRegion(onSelected func(PercentRect)) {
+	pw.selectRegion(true, onSelected)
+}
+
+func (pw *PreviewWidget) selectRegion(source bool, onSelected func(PercentRect)) {
+	pw.showSource = source
+	pw.UpdatePreview()
+	pw.imageCard.SetSubTitle("Drag on the preview to draw a region")
+	pw.selector.Select(func(region PercentRect) {
+		pw.showSource = false
+		onSelected(region)
+	}, func() {
+		// Nothing was drawn, go back to the normal preview and subtitle
+		pw.showSource = false
+		pw.UpdatePreview()
+	})
+}
+
 // UpdatePreview updates the preview with the current image and watermark
 func (pw *PreviewWidget) UpdatePreview() {
 	if len(appData.Images) == 0 || appData.CurrentImage >= len(appData.Images) {
@@ -54,7 +90,7 @@ func (pw *PreviewWidget) UpdatePreview() {
 	imagePath := appData.Images[appData.CurrentImage]
 
 	// Load and process the image
-	img, err := imaging.Open(imagePath)
+	img, err := loadImage(imagePath)
 	if err != nil {
 		// If image loading fails, show error message
 		pw.imageObj.Resource = nil
@@ -63,8 +99,31 @@ func (pw *PreviewWidget) UpdatePreview() {
 		return
 	}
 
-	// Apply watermark
-	watermarkedImg := applyWatermark(img)
+	// Apply redactions
+	ctx := &ImageContext{Path: imagePath, Index: appData.CurrentImage, Total: len(appData.Images)}
+	if appData.Manifest != nil {
+		if row := appData.Manifest.previewRow(imagePath); row != nil {
+			ctx.Row = row.Number
+			ctx.Vars = row.Vars
+		}
+	}
+	img = applyRedactions(img, ctx)
+
+	if pw.showSource {
+		// Show the original frame with the crop box outlined
+		sourceImg := drawRegionOutlines(img, cropOutline(img, ctx), color.RGBA{R: 64, G: 160, B: 255, A: 255})
+		pw.selector.SetImageSize(sourceImg.Bounds().Size())
+		pw.imageObj.Resource = fyne.NewStaticResource("preview", imageToBytes(sourceImg))
+		pw.imageCard.SetSubTitle("Original image")
+		pw.imageObj.Refresh()
+		return
+	}
+
+	// Crop, resize, adjust and apply watermark
+	img = applyCrop(img, ctx)
+	img = applyResize(img, ctx)
+	img = applyAdjustments(img, ctx)
+	watermarkedImg := applyWatermark(img, ctx)
 	if watermarkedImg == nil {
 		// If watermarking fails, show original image
 		resource := fyne.NewStaticResource("preview", imageToBytes(img))
@@ -74,10 +133,20 @@ func (pw *PreviewWidget) UpdatePreview() {
 		return
 	}
 
+	// Outline exclusion zones so they can be reviewed
+	watermarkedImg = drawRegionOutlines(watermarkedImg, appData.Watermark.ExclusionZones, color.RGBA{R: 255, G: 64, B: 64, A: 255})
+	pw.selector.SetImageSize(watermarkedImg.Bounds().Size())
+
 	// Convert to Fyne resource
 	resource := fyne.NewStaticResource("preview", imageToBytes(watermarkedImg))
 	pw.imageObj.Resource = resource
-	pw.imageCard.SetSubTitle("Image with watermark")
+	if ctx.Position == "" {
+		pw.imageCard.SetSubTitle("Watermark skipped (exclusion zone)")
+	} else if appData.Watermark.Position == "auto" {
+		pw.imageCard.SetSubTitle("Image with watermark (auto: " + ctx.Position + ")")
+	} else {
+		pw.imageCard.SetSubTitle("Image with watermark")
+	}
 	pw.imageObj.Refresh()
 }
 
@@ -96,43 +165,125 @@ func imageToBytes(img image.Image) []byte {
 }
 
 // Enhanced watermark application with better text rendering
-func applyWatermark(img image.Image) image.Image {
+func applyWatermark(img image.Image, ctx *ImageContext) image.Image {
 	bounds := img.Bounds()
 	watermarked := image.NewRGBA(bounds)
 	draw.Draw(watermarked, bounds, img, bounds.Min, draw.Src)
+	ctx.Size = bounds.Size()
+
+	var layer image.Image
+	switch watermarkType() {
+	case "image":
+		layer = renderImageWatermark(bounds)
+	case "qr":
+		layer = renderQRWatermark(ctx)
+	case "barcode":
+		layer = renderBarcodeWatermark(ctx)
+	default:
+		layer = renderTextWatermark(ctx)
+	}
+	if layer == nil {
+		return watermarked
+	}
 
-	if appData.Watermark.IsImage {
-		return applyImageWatermark(watermarked)
+	// Apply rotation, scale and opacity, including any per-image jitter
+	jitter := computeJitter(ctx)
+	if codeWatermark() && (jitter.Scale != 1 || appData.Watermark.Warp.Enabled) {
+		ctx.Logf("scale jitter and warp skipped so the code stays scannable")
+	}
+	layer = transformLayer(layer, jitter)
+	layer = warpLayer(layer, ctx)
+
+	// Calculate position
+	layerBounds := layer.Bounds()
+	ctx.Position = resolvePosition(watermarked, layerBounds, ctx)
+	if ctx.Position == "" {
+		return watermarked
+	}
+	x, y := calculateImagePosition(bounds, layerBounds, ctx.Position)
+
+	// Jitter must not push the mark into an exclusion zone
+	jittered := image.Rect(x+jitter.DX, y+jitter.DY, x+jitter.DX+layerBounds.Dx(), y+jitter.DY+layerBounds.Dy())
+	if overlapsExclusionZone(bounds, jittered) {
+		ctx.Logf("jitter offset dropped to avoid an exclusion zone")
+	} else {
+		x += jitter.DX
+		y += jitter.DY
+	}
+
+	// Draw watermark
+	drawRect := image.Rect(x, y, x+layerBounds.Dx(), y+layerBounds.Dy())
+	if knockoutApplies() {
+		drawKnockout(watermarked, drawRect, layer)
 	} else {
-		return applyTextWatermark(watermarked)
+		draw.Draw(watermarked, drawRect, layer, layerBounds.Min, draw.Over)
 	}
+
+	return watermarked
 }
 
-// Simple and reliable text watermark
-func applyTextWatermark(img *image.RGBA) *image.RGBA {
-	text := appData.Watermark.Text
-	if text == "" {
-		text = "WATERMARK" // Default text if empty
+// codeWatermark reports whether the watermark is a QR code or barcode, which
+// must not be resampled or bent to stay scannable
+func codeWatermark() bool {
+	t := watermarkType()
+	return t == "qr" || t == "barcode"
+}
+
+// watermarkType returns "text", "image", "qr" or "barcode". Templates saved before the
+// Type field existed only carry IsImage.
+func watermarkType() string {
+	if appData.Watermark.Type != "" {
+		return appData.Watermark.Type
+	}
+	if appData.Watermark.IsImage {
+		return "image"
+	}
+	return "text"
+}
+
+// transformLayer scales, rotates and fades the watermark layer
+func transformLayer(layer image.Image, jitter jitterParams) image.Image {
+	code := codeWatermark()
+	if jitter.Scale != 1 && !code {
+		b := layer.Bounds()
+		layer = imaging.Resize(layer,
+			max(1, int(float64(b.Dx())*jitter.Scale)),
+			max(1, int(float64(b.Dy())*jitter.Scale)),
+			imaging.Lanczos)
 	}
 
-	// Create a new image for the watermark
-	watermarkImg := image.NewRGBA(img.Bounds())
-	draw.Draw(watermarkImg, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	angle := appData.Watermark.Rotation + jitter.Rotation
+	if code {
+		// Quarter turns move whole pixels and keep the modules sharp
+		angle = math.Round(angle/90) * 90
+	}
+	if angle != 0 {
+		layer = imaging.Rotate(layer, angle, color.Transparent)
+	}
 
-	// Calculate position
-	x, y := calculateTextPosition(img.Bounds(), text, appData.Watermark.FontSize)
+	opacity := appData.Watermark.Opacity + jitter.Opacity
+	opacity = max(0, min(100, opacity))
+	faded := imaging.Clone(layer)
+	if opacity < 100 {
+		for i := 3; i < len(faded.Pix); i += 4 {
+			faded.Pix[i] = uint8(int(faded.Pix[i]) * opacity / 100)
+		}
+	}
+	return faded
+}
+
+// Simple and reliable text watermark layer
+func renderTextWatermark(ctx *ImageContext) image.Image {
+	text := expandTokens(appData.Watermark.Text, ctx)
+	if text == "" {
+		text = "WATERMARK" // Default text if empty
+	}
 
 	// Create font face
 	face := basicfont.Face7x13
 
-	// Apply opacity to color
-	opacity := float64(appData.Watermark.Opacity) / 100.0
-	textColor := color.RGBA{
-		R: appData.Watermark.Color.R,
-		G: appData.Watermark.Color.G,
-		B: appData.Watermark.Color.B,
-		A: uint8(float64(appData.Watermark.Color.A) * opacity),
-	}
+	// Opacity is applied to the whole layer in transformLayer
+	textColor := appData.Watermark.Color
 
 	// Create a temporary image for the text
 	textWidth := len(text) * 8 // Estimate width
@@ -160,34 +311,22 @@ func applyTextWatermark(img *image.RGBA) *image.RGBA {
 	// Scale the text image
 	scaledWidth := int(float64(textWidth) * scale)
 	scaledHeight := int(float64(textHeight) * scale)
-	scaledTextImg := imaging.Resize(textImg, scaledWidth, scaledHeight, imaging.Lanczos)
-
-	// Draw the scaled text onto the watermark image
-	textBounds := scaledTextImg.Bounds()
-	drawRect := image.Rect(x, y-textBounds.Dy(), x+textBounds.Dx(), y)
-	draw.Draw(watermarkImg, drawRect, scaledTextImg, textBounds.Min, draw.Over)
-
-	return watermarkImg
+	return imaging.Resize(textImg, scaledWidth, scaledHeight, imaging.Lanczos)
 }
 
-// Enhanced image watermark with better positioning and scaling
-func applyImageWatermark(img *image.RGBA) *image.RGBA {
+// Enhanced image watermark layer with better scaling
+func renderImageWatermark(imgBounds image.Rectangle) image.Image {
 	if appData.Watermark.ImagePath == "" {
-		return img
+		return nil
 	}
 
 	// Load watermark image
-	watermarkImg, err := imaging.Open(appData.Watermark.ImagePath)
+	watermarkImg, err := loadImage(appData.Watermark.ImagePath)
 	if err != nil {
-		return img
+		return nil
 	}
 
-	// Create result image
-	result := image.NewRGBA(img.Bounds())
-	draw.Draw(result, img.Bounds(), img, img.Bounds().Min, draw.Src)
-
 	// Calculate appropriate size (max 1/4 of image width or height)
-	imgBounds := img.Bounds()
 	watermarkBounds := watermarkImg.Bounds()
 
 	maxSize := min(imgBounds.Dx(), imgBounds.Dy()) / 4
@@ -197,58 +336,9 @@ func applyImageWatermark(img *image.RGBA) *image.RGBA {
 			int(float64(watermarkBounds.Dx())*scale),
 			int(float64(watermarkBounds.Dy())*scale),
 			imaging.Lanczos)
-		watermarkBounds = watermarkImg.Bounds()
 	}
 
-	// Calculate position
-	x, y := calculateImagePosition(imgBounds, watermarkBounds, appData.Watermark.Position)
-
-	// Apply opacity - for now we'll skip this as imaging.AdjustOpacity may not exist
-	// opacity := float64(appData.Watermark.Opacity) / 100.0
-	// if opacity < 1.0 {
-	//     watermarkImg = imaging.AdjustOpacity(watermarkImg, opacity)
-	// }
-
-	// Draw watermark
-	draw.Draw(result,
-		image.Rect(x, y, x+watermarkBounds.Dx(), y+watermarkBounds.Dy()),
-		watermarkImg,
-		watermarkBounds.Min,
-		draw.Over)
-
-	return result
-}
-
-// calculateTextPosition calculates text position based on selected position
-func calculateTextPosition(bounds image.Rectangle, text string, fontSize int) (int, int) {
-	// Estimate text dimensions
-	textWidth := len(text) * fontSize / 2
-	textHeight := fontSize
-
-	margin := 10
-
-	switch appData.Watermark.Position {
-	case "top-left":
-		return margin, margin + textHeight
-	case "top-center":
-		return (bounds.Dx() - textWidth) / 2, margin + textHeight
-	case "top-right":
-		return bounds.Dx() - textWidth - margin, margin + textHeight
-	case "center-left":
-		return margin, bounds.Dy() / 2
-	case "center":
-		return (bounds.Dx() - textWidth) / 2, bounds.Dy() / 2
-	case "center-right":
-		return bounds.Dx() - textWidth - margin, bounds.Dy() / 2
-	case "bottom-left":
-		return margin, bounds.Dy() - margin
-	case "bottom-center":
-		return (bounds.Dx() - textWidth) / 2, bounds.Dy() - margin
-	case "bottom-right":
-		return bounds.Dx() - textWidth - margin, bounds.Dy() - margin
-	default:
-		return margin, bounds.Dy() - margin
-	}
+	return watermarkImg
 }
 
 // calculateImagePosition calculates image watermark position
@@ -274,6 +364,8 @@ func calculateImagePosition(imgBounds, watermarkBounds image.Rectangle, position
 		return (imgBounds.Dx() - watermarkBounds.Dx()) / 2, imgBounds.Dy() - watermarkBounds.Dy() - margin
 	case "bottom-right":
 		return imgBounds.Dx() - watermarkBounds.Dx() - margin, imgBounds.Dy() - watermarkBounds.Dy() - margin
+	case "manual":
+		return appData.Watermark.X, appData.Watermark.Y
 	default:
 		return margin, imgBounds.Dy() - watermarkBounds.Dy() - margin
 	}
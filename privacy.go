@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// MetadataPolicy decides which metadata survives export
+type MetadataPolicy struct {
+	Name  string   // "keep-all", "strip-all", "strip-private" or "custom"
+	Allow []string // for "custom": when set, only these tags are kept
+	Deny  []string // for "custom": these tags are always removed
+}
+
+// metadataPolicyNames lists the selectable policies
+var metadataPolicyNames = []string{"keep-all", "strip-all", "strip-private", "custom"}
+
+// exifTagNames names the tags policies and logs refer to. Other tags are
+// referred to by their hex ID, e.g. 0xA431.
+var exifTagNames = map[uint16]string{
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x013C: "HostComputer",
+	0x8298: "Copyright",
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8827: "ISOSpeedRatings",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x927C: "MakerNote",
+	0x920A: "FocalLength",
+	0x9286: "UserComment",
+	0xA002: "PixelXDimension",
+	0xA003: "PixelYDimension",
+	0xA420: "ImageUniqueID",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+	0xC62F: "CameraSerialNumber",
+}
+
+// privateExifTags identify the camera or its owner. GPS is removed as a whole.
+var privateExifTags = map[uint16]bool{
+	0x013C: true, // HostComputer
+	0x927C: true, // MakerNote, which usually holds the serial number
+	0xA420: true, // ImageUniqueID
+	0xA430: true, // CameraOwnerName
+	0xA431: true, // BodySerialNumber
+	0xA435: true, // LensSerialNumber
+	0xC62F: true, // CameraSerialNumber
+}
+
+// xmpPrivateNames are the XMP location, serial number and owner properties;
+// "exif:GPS" covers every GPS property
+const xmpPrivateNames = `(?:exif:GPS\w*|aux:SerialNumber|aux:LensSerialNumber|aux:OwnerName|exifEX:BodySerialNumber|` +
+	`exifEX:LensSerialNumber|exifEX:CameraOwnerName|Iptc4xmpCore:Location|photoshop:City|photoshop:State|photoshop:Country)`
+
+// xmpPrivateProperties matches the private properties in element and
+// attribute form
+var xmpPrivateProperties = regexp.MustCompile(
+	`(?s)<` + xmpPrivateNames + `\b[^>]*?(?:/>|>.*?</` + xmpPrivateNames + `>)|\s` + xmpPrivateNames + `="[^"]*"`)
+
+// exifTagName returns the name of a tag in a directory
+func exifTagName(group string, tag uint16) string {
+	if name, ok := exifTagNames[tag]; ok && group != "GPS" && group != "Interop" {
+		return name
+	}
+	return fmt.Sprintf("%s:0x%04X", group, tag)
+}
+
+// matches reports whether a list refers to any of the names
+func (p MetadataPolicy) matches(list []string, names ...string) bool {
+	for _, item := range list {
+		for _, name := range names {
+			if strings.EqualFold(strings.TrimSpace(item), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keepCustom reports whether the custom lists keep an item known by names
+func (p MetadataPolicy) keepCustom(names ...string) bool {
+	if p.matches(p.Deny, names...) {
+		return false
+	}
+	return len(p.Allow) == 0 || p.matches(p.Allow, names...)
+}
+
+// keepExif reports whether the policy keeps an EXIF tag
+func (p MetadataPolicy) keepExif(group string, tag uint16) bool {
+	switch p.Name {
+	case "strip-all":
+		return false
+	case "strip-private":
+		return group != "GPS" && !privateExifTags[tag]
+	case "custom":
+		return p.keepCustom(exifTagName(group, tag), fmt.Sprintf("0x%04X", tag), group)
+	}
+	return true
+}
+
+// applyMetadataPolicy removes the metadata the chosen policy doesn't allow
+// and records what was removed in the export log
+func applyMetadataPolicy(md *Metadata, ctx *ImageContext) {
+	policy := appData.MetadataPolicy
+	if policy.Name == "" || policy.Name == "keep-all" {
+		return
+	}
+	var removed []string
+
+	if len(md.Exif) > 0 {
+		exif, err := parseExif(md.Exif)
+		if err != nil {
+			md.Exif = nil
+			removed = append(removed, "EXIF (unreadable)")
+		} else {
+			filter := func(group string, entries *[]exifEntry) {
+				kept := (*entries)[:0]
+				for _, entry := range *entries {
+					if policy.keepExif(group, entry.Tag) {
+						kept = append(kept, entry)
+					} else {
+						removed = append(removed, exifTagName(group, entry.Tag))
+					}
+				}
+				*entries = kept
+			}
+			filter("IFD0", &exif.ifd0)
+			filter("Exif", &exif.exif)
+			filter("GPS", &exif.gps)
+			filter("Interop", &exif.interop)
+
+			if len(exif.thumbnail) > 0 && !policy.keepExif("Thumbnail", tagThumbnailOffset) {
+				exif.ifd1, exif.thumbnail = nil, nil
+				removed = append(removed, "Thumbnail")
+			}
+
+			if len(exif.ifd0) == 0 && len(exif.exif) == 0 && len(exif.gps) == 0 && len(exif.thumbnail) == 0 {
+				md.Exif = nil
+			} else {
+				md.Exif = exif.encode()
+			}
+		}
+	}
+
+	if len(md.XMP) > 0 {
+		switch policy.Name {
+		case "strip-all":
+			md.XMP = nil
+			removed = append(removed, "XMP")
+		case "strip-private":
+			count := len(xmpPrivateProperties.FindAll(md.XMP, -1))
+			if count > 0 {
+				md.XMP = xmpPrivateProperties.ReplaceAll(md.XMP, nil)
+				removed = append(removed, fmt.Sprintf("XMP private properties (%d)", count))
+			}
+		case "custom":
+			if !policy.keepCustom("XMP") {
+				md.XMP = nil
+				removed = append(removed, "XMP")
+			}
+		}
+	}
+
+	// The color profile is kept unless a custom policy removes it
+	if len(md.ICC) > 0 && policy.Name == "custom" && !policy.keepCustom("ICC") {
+		md.ICC = nil
+		removed = append(removed, "ICC")
+	}
+
+	kept := md.Text[:0]
+	for _, text := range md.Text {
+		keep := true
+		switch policy.Name {
+		case "strip-all":
+			keep = false
+		case "custom":
+			keep = policy.keepCustom(text.Keyword, "Text")
+		}
+		if keep {
+			kept = append(kept, text)
+		} else {
+			removed = append(removed, "Text:"+text.Keyword)
+		}
+	}
+	md.Text = kept
+
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		ctx.Logf("metadata policy %s removed: %s", policy.Name, strings.Join(removed, ", "))
+	}
+}
+
+// parseTagList splits a comma separated list of tag names
+func parseTagList(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// createPrivacyControls creates the metadata policy settings
+func createPrivacyControls() *fyne.Container {
+	allowEntry := widget.NewEntry()
+	allowEntry.SetPlaceHolder("e.g. Copyright, Artist, ICC")
+	allowEntry.SetText(strings.Join(appData.MetadataPolicy.Allow, ", "))
+	allowEntry.OnChanged = func(text string) {
+		appData.MetadataPolicy.Allow = parseTagList(text)
+	}
+
+	denyEntry := widget.NewEntry()
+	denyEntry.SetPlaceHolder("e.g. GPS, Make, Model, 0xA431")
+	denyEntry.SetText(strings.Join(appData.MetadataPolicy.Deny, ", "))
+	denyEntry.OnChanged = func(text string) {
+		appData.MetadataPolicy.Deny = parseTagList(text)
+	}
+
+	customFields := container.NewGridWithColumns(2,
+		widget.NewLabel("Allow Only:"),
+		allowEntry,
+		widget.NewLabel("Deny:"),
+		denyEntry,
+	)
+
+	policySelect := widget.NewSelect(metadataPolicyNames, func(value string) {
+		appData.MetadataPolicy.Name = value
+		if value == "custom" {
+			customFields.Show()
+		} else {
+			customFields.Hide()
+		}
+	})
+	policySelect.SetSelected(appData.MetadataPolicy.Name)
+
+	return container.NewVBox(
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Policy:"),
+			policySelect,
+		),
+		customFields,
+		widget.NewLabel("Tags: names, hex IDs or GPS, Exif, Thumbnail, XMP, ICC, Text"),
+	)
+}
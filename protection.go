@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// parseOwnerID reads an owner ID in decimal or 0x-prefixed hex
+func parseOwnerID(text string) (uint32, error) {
+	text = strings.TrimSpace(text)
+	if hex, ok := strings.CutPrefix(strings.ToLower(text), "0x"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		return uint32(v), err
+	}
+	v, err := strconv.ParseUint(text, 10, 32)
+	return uint32(v), err
+}
+
+// CreateProtectionControls creates the invisible watermark and protection controls
+func (ec *EnhancedControls) CreateProtectionControls() *fyne.Container {
+	cfg := &appData.Invisible
+
+	enableCheck := widget.NewCheck("Embed invisible watermark on export", func(checked bool) {
+		cfg.Enabled = checked
+	})
+	enableCheck.SetChecked(cfg.Enabled)
+
+	keyEntry := widget.NewPasswordEntry()
+	keyEntry.SetPlaceHolder("Secret key")
+	keyEntry.SetText(cfg.Key)
+	keyEntry.OnChanged = func(text string) {
+		cfg.Key = text
+	}
+
+	ownerEntry := widget.NewEntry()
+	ownerEntry.SetPlaceHolder("e.g. 1234 or 0x00C0FFEE")
+	ownerEntry.SetText(strconv.FormatUint(uint64(cfg.OwnerID), 10))
+	ownerEntry.OnChanged = func(text string) {
+		if id, err := parseOwnerID(text); err == nil {
+			cfg.OwnerID = id
+		}
+	}
+
+	strengthLabel := widget.NewLabel("Strength: " + strconv.Itoa(cfg.Strength))
+	strengthSlider := widget.NewSlider(1, 100)
+	strengthSlider.Value = float64(cfg.Strength)
+	strengthSlider.OnChanged = func(value float64) {
+		cfg.Strength = int(value)
+		strengthLabel.SetText("Strength: " + strconv.Itoa(cfg.Strength))
+	}
+
+	return container.NewVBox(
+		widget.NewLabel("Invisible Watermark"),
+		widget.NewSeparator(),
+		enableCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Key:"),
+			keyEntry,
+			widget.NewLabel("Owner ID:"),
+			ownerEntry,
+		),
+		strengthLabel,
+		strengthSlider,
+		widget.NewLabel("Higher strength survives more recompression and\nresizing; flat images such as graphics need more."),
+		widget.NewButton("Inspect Suspect Image...", func() {
+			ec.ShowInspectDialog()
+		}),
+		widget.NewLabel(""),
+		ec.createFingerprintControls(),
+		widget.NewLabel(""),
+		ec.createFragileControls(),
+		widget.NewLabel(""),
+		ec.createProvenanceControls(),
+		widget.NewLabel(""),
+		ec.createReversibleControls(),
+		widget.NewLabel(""),
+		widget.NewLabel("Audit Log"),
+		widget.NewSeparator(),
+		widget.NewLabel("Every exported file is recorded with its hashes, the template\n"+
+			"and the user in a hash-chained log that shows later edits. The audit\n"+
+			"key is kept with the log, so it can't stop someone with access to\n"+
+			"the app data folder from rewriting and re-signing it."),
+		widget.NewButton("View Audit Log...", func() {
+			ec.ShowAuditLog()
+		}),
+	)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// provenanceKeyName is the app's Ed25519 signing key in the app data folder
+	provenanceKeyName = "provenance_key.pem"
+
+	// provenanceSidecarExt is appended to the output name for sidecar manifests
+	provenanceSidecarExt = ".provenance.json"
+)
+
+// provenanceModes lists where the signed manifest can be written
+var provenanceModes = []string{"off", "embed", "sidecar", "both"}
+
+// ProvenanceManifest describes how an export was made. It is signed as JSON.
+type ProvenanceManifest struct {
+	Tool       string
+	Version    string
+	Created    time.Time
+	Source     string // file name of the source image
+	SourceHash string // SHA-256 of the source file
+	PixelHash  string // SHA-256 of the decoded output pixels
+	Width      int
+	Height     int
+	Template   string // name of the template last saved or loaded
+	Settings   string // SHA-256 of the watermark settings used
+	PublicKey  []byte // Ed25519 key the signature verifies with
+}
+
+// signedProvenance is the manifest as signed, with its signature
+type signedProvenance struct {
+	Manifest  json.RawMessage
+	Signature []byte
+}
+
+// provenanceKeyPath returns where the signing key is kept
+func provenanceKeyPath() string {
+	return filepath.Join(appDataDir(), provenanceKeyName)
+}
+
+// loadSigningKey reads the app's signing key. When there is none and create
+// is set, a new key is generated and saved.
+func loadSigningKey(create bool) (ed25519.PrivateKey, error) {
+	return loadKey(provenanceKeyPath(), create)
+}
+
+// loadKey reads an Ed25519 private key from a PEM file, generating and
+// saving a new one when there is none and create is set
+func loadKey(path string, create bool) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: not a PEM file", filepath.Base(path))
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", filepath.Base(path))
+	}
+	return key, nil
+}
+
+// readPublicKey reads an Ed25519 public key from a PEM file
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key: not a PEM file")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %v", err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key: not an Ed25519 key")
+	}
+	return key, nil
+}
+
+// keyFingerprint returns a short form of a public key for display
+func keyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// hashBytes returns data's SHA-256 in the form stored in manifests
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// pixelHash hashes the size and 8-bit RGBA values of an image, so it only
+// depends on what the file decodes to
+func pixelHash(img image.Image) string {
+	src := imaging.Clone(img)
+	h := sha256.New()
+	var size [8]byte
+	binary.BigEndian.PutUint32(size[0:], uint32(src.Bounds().Dx()))
+	binary.BigEndian.PutUint32(size[4:], uint32(src.Bounds().Dy()))
+	h.Write(size[:])
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		h.Write(src.Pix[y*src.Stride : y*src.Stride+src.Bounds().Dx()*4])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// createProvenance signs a manifest for the image as it will decode from
+// the exported file. JPEG output is encoded once here to learn its pixels.
+func createProvenance(img image.Image, ctx *ImageContext) ([]byte, error) {
+	key, err := loadSigningKey(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded bytes.Buffer
+	if err := encodeImage(&encoded, img, appData.OutputFormat, appData.OutputQuality, nil); err != nil {
+		return nil, err
+	}
+	decoded, _, err := image.Decode(&encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := os.ReadFile(ctx.Path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := json.Marshal(appData.Watermark)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := json.Marshal(ProvenanceManifest{
+		Tool:       "watermark-app",
+		Version:    appVersion,
+		Created:    time.Now().UTC(),
+		Source:     filepath.Base(ctx.Path),
+		SourceHash: hashBytes(source),
+		PixelHash:  pixelHash(decoded),
+		Width:      decoded.Bounds().Dx(),
+		Height:     decoded.Bounds().Dy(),
+		Template:   appData.TemplateName,
+		Settings:   hashBytes(settings),
+		PublicKey:  key.Public().(ed25519.PublicKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx.Logf("provenance signed with key %s", keyFingerprint(key.Public().(ed25519.PublicKey)))
+
+	// Indenting would reformat the signed manifest bytes
+	return json.Marshal(signedProvenance{
+		Manifest:  manifest,
+		Signature: ed25519.Sign(key, manifest),
+	})
+}
+
+// saveProvenanceSidecar writes the signed manifest next to the output when
+// the mode asks for it, or when it couldn't be embedded
+func saveProvenanceSidecar(outputPath string, signed []byte, embedded bool, ctx *ImageContext) error {
+	mode := appData.Provenance
+	if len(signed) == 0 || (embedded && mode == "embed") {
+		return nil
+	}
+	if !embedded && mode == "embed" {
+		ctx.Warnf("provenance could not be embedded, wrote a sidecar file instead")
+	}
+	ctx.Sidecars = append(ctx.Sidecars, outputPath+provenanceSidecarExt)
+	return os.WriteFile(outputPath+provenanceSidecarExt, signed, 0644)
+}
+
+// provenanceReport is the outcome of verifying an image's provenance
+type provenanceReport struct {
+	Manifest       ProvenanceManifest
+	Sidecar        string // path of the sidecar read, empty when embedded
+	SignatureValid bool
+	KnownKey       bool // signed by the trusted key
+	PixelsMatch    bool
+	PixelsChecked  bool
+}
+
+// OK reports whether the image is signed with the trusted key and unchanged
+func (r provenanceReport) OK() bool {
+	return r.SignatureValid && r.KnownKey && r.PixelsMatch
+}
+
+// String formats the report
+func (r provenanceReport) String() string {
+	m := r.Manifest
+	var lines []string
+	if r.Sidecar != "" {
+		lines = append(lines, "Manifest: sidecar "+filepath.Base(r.Sidecar))
+	} else {
+		lines = append(lines, "Manifest: embedded")
+	}
+
+	key := "key " + keyFingerprint(m.PublicKey)
+	switch {
+	case !r.SignatureValid:
+		lines = append(lines, "Signature: INVALID, the manifest was altered")
+	case r.KnownKey:
+		lines = append(lines, "Signature: valid ("+key+", trusted)")
+	default:
+		lines = append(lines, "Signature: valid, but by an unknown "+key)
+	}
+
+	switch {
+	case !r.PixelsChecked:
+		lines = append(lines, "Pixels: could not be decoded")
+	case r.PixelsMatch:
+		lines = append(lines, "Pixels: unchanged since signing")
+	default:
+		lines = append(lines, "Pixels: CHANGED since signing")
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Signed: %s by %s %s", m.Created.Local().Format("2006-01-02 15:04:05"), m.Tool, m.Version),
+		fmt.Sprintf("Source: %s (%s)", m.Source, m.SourceHash),
+		fmt.Sprintf("Size: %dx%d", m.Width, m.Height))
+	if m.Template != "" {
+		lines = append(lines, "Template: "+m.Template)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// verifyProvenance checks the embedded or sidecar manifest of a file. The
+// trusted key is the app's own key unless one is given.
+func verifyProvenance(path string, trusted ed25519.PublicKey) (provenanceReport, error) {
+	var report provenanceReport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+
+	var signed []byte
+	if md, err := readMetadata(path); err == nil {
+		signed = md.Provenance
+	}
+	if len(signed) == 0 {
+		report.Sidecar = path + provenanceSidecarExt
+		if signed, err = os.ReadFile(report.Sidecar); err != nil {
+			return report, errors.New("no provenance manifest found")
+		}
+	}
+
+	var envelope signedProvenance
+	if err := json.Unmarshal(signed, &envelope); err != nil {
+		return report, fmt.Errorf("provenance: %v", err)
+	}
+	if err := json.Unmarshal(envelope.Manifest, &report.Manifest); err != nil {
+		return report, fmt.Errorf("provenance: %v", err)
+	}
+
+	publicKey := ed25519.PublicKey(report.Manifest.PublicKey)
+	report.SignatureValid = len(publicKey) == ed25519.PublicKeySize &&
+		ed25519.Verify(publicKey, envelope.Manifest, envelope.Signature)
+	if trusted == nil {
+		if key, err := loadSigningKey(false); err == nil {
+			trusted = key.Public().(ed25519.PublicKey)
+		}
+	}
+	report.KnownKey = trusted != nil && trusted.Equal(publicKey)
+
+	if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		report.PixelsChecked = true
+		report.PixelsMatch = pixelHash(img) == report.Manifest.PixelHash
+	}
+	return report, nil
+}
+
+// verifyCommand implements "verify [-pubkey FILE] FILE..."
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	pubkey := flags.String("pubkey", "", "PEM public key to trust (default: this app's signing key)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: watermark-app verify [-pubkey FILE] IMAGE...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var trusted ed25519.PublicKey
+	if *pubkey != "" {
+		key, err := readPublicKey(*pubkey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		trusted = key
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		report, err := verifyProvenance(path, trusted)
+		if err != nil {
+			fmt.Printf("%s:\n  %v\n", path, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s:\n  %s\n", path, strings.ReplaceAll(report.String(), "\n", "\n  "))
+		if !report.OK() {
+			code = 1
+		}
+	}
+	return code
+}
+
+// createProvenanceControls creates the signed provenance settings
+func (ec *EnhancedControls) createProvenanceControls() *fyne.Container {
+	keyLabel := widget.NewLabel("")
+	updateKeyLabel := func() {
+		if key, err := loadSigningKey(false); err == nil {
+			keyLabel.SetText("Signing key: " + keyFingerprint(key.Public().(ed25519.PublicKey)))
+		} else {
+			keyLabel.SetText("Signing key: created on the first signed export")
+		}
+	}
+	updateKeyLabel()
+
+	modeSelect := widget.NewSelect(provenanceModes, func(value string) {
+		appData.Provenance = value
+	})
+	modeSelect.SetSelected(appData.Provenance)
+
+	exportKeyBtn := widget.NewButton("Export Public Key...", func() {
+		key, err := loadSigningKey(true)
+		if err != nil {
+			dialog.ShowError(err, ec.window)
+			return
+		}
+		updateKeyLabel()
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			dialog.ShowError(err, ec.window)
+			return
+		}
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, ec.window)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			defer writer.Close()
+			if _, err := writer.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+				dialog.ShowError(err, ec.window)
+			}
+		}, ec.window)
+		saveDialog.SetFileName("watermark_public_key.pem")
+		saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".pem"}))
+		saveDialog.Show()
+	})
+
+	verifyBtn := widget.NewButton("Verify Provenance...", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, ec.window)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			reader.Close()
+			path := reader.URI().Path()
+
+			report, err := verifyProvenance(path, nil)
+			if err != nil {
+				dialog.ShowError(err, ec.window)
+				return
+			}
+			dialog.ShowInformation("Provenance: "+filepath.Base(path), report.String(), ec.window)
+		}, ec.window)
+	})
+
+	return container.NewVBox(
+		widget.NewLabel("Signed Provenance"),
+		widget.NewSeparator(),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Manifest:"),
+			modeSelect,
+		),
+		keyLabel,
+		widget.NewLabel("Signs the source hash, output pixels, template and time with\n"+
+			"the app's Ed25519 key. Share the public key to let others verify."),
+		container.NewGridWithColumns(2, exportKeyBtn, verifyBtn),
+	)
+}
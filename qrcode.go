@@ -0,0 +1,129 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/boombuler/barcode/qr"
+)
+
+// QRConfig holds the QR code watermark settings
+type QRConfig struct {
+	Content   string
+	Level     string // "L", "M", "Q" or "H"
+	Color     color.RGBA
+	QuietZone int // in modules
+	Size      int // in pixels
+}
+
+// qrLevels maps the level names to the encoder's error correction levels
+var qrLevels = map[string]qr.ErrorCorrectionLevel{
+	"L": qr.L,
+	"M": qr.M,
+	"Q": qr.Q,
+	"H": qr.H,
+}
+
+// renderQRWatermark encodes the QR content and draws it as a watermark layer
+func renderQRWatermark(ctx *ImageContext) image.Image {
+	cfg := appData.Watermark.QR
+	if cfg.Content == "" {
+		return nil
+	}
+
+	level, ok := qrLevels[cfg.Level]
+	if !ok {
+		level = qr.M
+	}
+	code, err := qr.Encode(expandTokens(cfg.Content, ctx), level, qr.Auto)
+	if err != nil {
+		ctx.Logf("QR code not generated: %v", err)
+		return nil
+	}
+
+	// Use whole pixels per module so the code stays sharp and scannable
+	modules := code.Bounds().Dx()
+	quiet := max(0, cfg.QuietZone)
+	moduleSize := max(1, cfg.Size/(modules+2*quiet))
+	side := (modules + 2*quiet) * moduleSize
+
+	layer := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(layer, layer.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	fg := image.NewUniform(cfg.Color)
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			r, _, _, _ := code.At(x, y).RGBA()
+			if r != 0 {
+				continue
+			}
+			px := (quiet + x) * moduleSize
+			py := (quiet + y) * moduleSize
+			draw.Draw(layer, image.Rect(px, py, px+moduleSize, py+moduleSize), fg, image.Point{}, draw.Src)
+		}
+	}
+
+	return layer
+}
+
+// createQRControls creates the QR code watermark settings
+func createQRControls(window fyne.Window) *fyne.Container {
+	contentEntry := widget.NewEntry()
+	contentEntry.SetPlaceHolder("URL or text, e.g. https://example.com/license")
+	contentEntry.SetText(appData.Watermark.QR.Content)
+	contentEntry.OnChanged = func(text string) {
+		appData.Watermark.QR.Content = text
+		updatePreview()
+	}
+
+	levelSelect := widget.NewSelect([]string{"L", "M", "Q", "H"}, func(value string) {
+		appData.Watermark.QR.Level = value
+		updatePreview()
+	})
+	levelSelect.SetSelected(appData.Watermark.QR.Level)
+
+	quietEntry := widget.NewEntry()
+	quietEntry.SetText(strconv.Itoa(appData.Watermark.QR.QuietZone))
+	quietEntry.OnChanged = func(text string) {
+		if quiet, err := strconv.Atoi(text); err == nil && quiet >= 0 && quiet <= 20 {
+			appData.Watermark.QR.QuietZone = quiet
+			updatePreview()
+		}
+	}
+
+	sizeEntry := widget.NewEntry()
+	sizeEntry.SetText(strconv.Itoa(appData.Watermark.QR.Size))
+	sizeEntry.OnChanged = func(text string) {
+		if size, err := strconv.Atoi(text); err == nil && size >= 21 && size <= 4000 {
+			appData.Watermark.QR.Size = size
+			updatePreview()
+		}
+	}
+
+	colorPicker := NewColorPicker(window)
+	colorBtn := widget.NewButton("Module Color", func() {
+		colorPicker.ShowColorPicker(appData.Watermark.QR.Color, func(selectedColor color.RGBA) {
+			appData.Watermark.QR.Color = selectedColor
+			updatePreview()
+		})
+	})
+
+	return container.NewVBox(
+		widget.NewLabel("QR Code Watermark:"),
+		contentEntry,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Error Correction:"),
+			levelSelect,
+			widget.NewLabel("Quiet Zone (modules):"),
+			quietEntry,
+			widget.NewLabel("Size (px):"),
+			sizeEntry,
+		),
+		colorBtn,
+	)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// Redaction hides an area of the photo before any watermark is drawn
+type Redaction struct {
+	Region   PercentRect
+	Mode     string // "blur", "pixelate" or "fill"
+	Strength int    // 1-100
+	Color    color.RGBA
+}
+
+// imageRedactionsName is the file keeping the regions drawn on individual
+// images between sessions
+const imageRedactionsName = "image_redactions.json"
+
+// loadImageRedactions reads the per-image redactions saved earlier
+func loadImageRedactions() map[string][]Redaction {
+	redactions := make(map[string][]Redaction)
+	data, err := os.ReadFile(filepath.Join(appDataDir(), imageRedactionsName))
+	if err != nil {
+		return redactions
+	}
+	json.Unmarshal(data, &redactions)
+	return redactions
+}
+
+// saveImageRedactions writes the per-image redactions to the app data folder
+func saveImageRedactions() {
+	dir := appDataDir()
+	os.MkdirAll(dir, 0755)
+	data, err := json.MarshalIndent(appData.Redactions, "", "  ")
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(dir, imageRedactionsName), data, 0644)
+}
+
+// redactionsFor returns the template-wide and per-image redactions for an image
+func redactionsFor(ctx *ImageContext) []Redaction {
+	redactions := append([]Redaction(nil), appData.Watermark.Redactions...)
+	if ctx != nil {
+		redactions = append(redactions, appData.Redactions[ctx.Path]...)
+	}
+	return redactions
+}
+
+// applyRedactions blurs, pixelates or fills the redaction regions
+func applyRedactions(img image.Image, ctx *ImageContext) image.Image {
+	redactions := redactionsFor(ctx)
+	if len(redactions) == 0 {
+		return img
+	}
+
+	redacted := imaging.Clone(img)
+	bounds := redacted.Bounds()
+	for _, redaction := range redactions {
+		rect := redaction.Region.Rect(bounds)
+		if rect.Empty() {
+			continue
+		}
+		strength := float64(max(1, min(100, redaction.Strength)))
+
+		switch redaction.Mode {
+		case "fill":
+			draw.Draw(redacted, rect, image.NewUniform(redaction.Color), image.Point{}, draw.Src)
+		case "pixelate":
+			block := max(2, int(float64(min(rect.Dx(), rect.Dy()))*(0.02+strength/400)))
+			small := imaging.Resize(imaging.Crop(redacted, rect),
+				max(1, rect.Dx()/block), max(1, rect.Dy()/block), imaging.Box)
+			blocky := imaging.Resize(small, rect.Dx(), rect.Dy(), imaging.NearestNeighbor)
+			draw.Draw(redacted, rect, blocky, image.Point{}, draw.Src)
+		default:
+			// Blur a margin around the region so the edges are hidden as well
+			sigma := 2 + strength/4
+			source := rect.Inset(-int(3 * sigma)).Intersect(bounds)
+			blurred := imaging.Blur(imaging.Crop(redacted, source), sigma)
+			draw.Draw(redacted, rect, blurred, rect.Min.Sub(source.Min), draw.Src)
+		}
+	}
+
+	ctx.Logf("redacted %d region(s)", len(redactions))
+	return redacted
+}
+
+// CreateRedactionControls creates redaction control widgets
+func (ec *EnhancedControls) CreateRedactionControls() *fyne.Container {
+	appData.Redactions = loadImageRedactions()
+
+	current := Redaction{
+		Mode:     "blur",
+		Strength: 50,
+		Color:    color.RGBA{R: 0, G: 0, B: 0, A: 255},
+	}
+
+	modeSelect := widget.NewSelect([]string{"blur", "pixelate", "fill"}, func(value string) {
+		current.Mode = value
+	})
+	modeSelect.SetSelected(current.Mode)
+
+	strengthSlider := widget.NewSlider(1, 100)
+	strengthSlider.Value = float64(current.Strength)
+	strengthSlider.OnChanged = func(value float64) {
+		current.Strength = int(value)
+	}
+
+	colorBtn := widget.NewButton("Fill Color", func() {
+		ec.colorPicker.ShowColorPicker(current.Color, func(selectedColor color.RGBA) {
+			current.Color = selectedColor
+		})
+	})
+
+	countLabel := widget.NewLabel("")
+	updateCount := func() {
+		imageCount := 0
+		if appData.CurrentImage < len(appData.Images) {
+			imageCount = len(appData.Redactions[appData.Images[appData.CurrentImage]])
+		}
+		countLabel.SetText("This image: " + strconv.Itoa(imageCount) +
+			", template: " + strconv.Itoa(len(appData.Watermark.Redactions)))
+	}
+	updateCount()
+	imageSelectedCallbacks = append(imageSelectedCallbacks, updateCount)
+
+	drawImageBtn := widget.NewButton("Draw on This Image", func() {
+		if globalPreviewWidget == nil || appData.CurrentImage >= len(appData.Images) {
+			return
+		}
+		path := appData.Images[appData.CurrentImage]
+		globalPreviewWidget.SelectSourceRegion(func(region PercentRect) {
+			redaction := current
+			redaction.Region = region
+			if appData.Redactions == nil {
+				appData.Redactions = make(map[string][]Redaction)
+			}
+			appData.Redactions[path] = append(appData.Redactions[path], redaction)
+			saveImageRedactions()
+			updateCount()
+			updatePreview()
+		})
+	})
+
+	drawTemplateBtn := widget.NewButton("Draw for All Images", func() {
+		if globalPreviewWidget == nil {
+			return
+		}
+		globalPreviewWidget.SelectSourceRegion(func(region PercentRect) {
+			redaction := current
+			redaction.Region = region
+			appData.Watermark.Redactions = append(appData.Watermark.Redactions, redaction)
+			updateCount()
+			updatePreview()
+		})
+	})
+
+	clearImageBtn := widget.NewButton("Clear This Image", func() {
+		if appData.CurrentImage < len(appData.Images) {
+			delete(appData.Redactions, appData.Images[appData.CurrentImage])
+			saveImageRedactions()
+		}
+		updateCount()
+		updatePreview()
+	})
+
+	clearTemplateBtn := widget.NewButton("Clear All-Image Regions", func() {
+		appData.Watermark.Redactions = nil
+		updateCount()
+		updatePreview()
+	})
+
+	return container.NewVBox(
+		widget.NewLabel("Redaction"),
+		widget.NewSeparator(),
+
+		widget.NewLabel("Mode:"),
+		modeSelect,
+		widget.NewLabel("Strength:"),
+		strengthSlider,
+		colorBtn,
+
+		widget.NewSeparator(),
+
+		widget.NewLabel("Regions (applied before the watermark):"),
+		container.NewGridWithColumns(2,
+			drawImageBtn,
+			drawTemplateBtn,
+			clearImageBtn,
+			clearTemplateBtn,
+		),
+		countLabel,
+	)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// PercentRect is a rectangle expressed in percent of the image size
+type PercentRect struct {
+	X float64
+	Y float64
+	W float64
+	H float64
+}
+
+// Rect converts the percent rectangle to pixels within bounds
+func (r PercentRect) Rect(bounds image.Rectangle) image.Rectangle {
+	w := float64(bounds.Dx())
+	h := float64(bounds.Dy())
+	rect := image.Rect(
+		bounds.Min.X+int(math.Round(r.X*w/100)),
+		bounds.Min.Y+int(math.Round(r.Y*h/100)),
+		bounds.Min.X+int(math.Round((r.X+r.W)*w/100)),
+		bounds.Min.Y+int(math.Round((r.Y+r.H)*h/100)),
+	)
+	return rect.Intersect(bounds)
+}
+
+// overlapsExclusionZone reports whether rect intersects any exclusion zone
+func overlapsExclusionZone(imgBounds, rect image.Rectangle) bool {
+	for _, zone := range appData.Watermark.ExclusionZones {
+		if zone.Rect(imgBounds).Overlaps(rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// drawRegionOutlines returns a copy of img with the given regions outlined
+func drawRegionOutlines(img image.Image, regions []PercentRect, c color.RGBA) image.Image {
+	if len(regions) == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	outlined := image.NewRGBA(bounds)
+	draw.Draw(outlined, bounds, img, bounds.Min, draw.Src)
+
+	width := max(2, min(bounds.Dx(), bounds.Dy())/300)
+	src := image.NewUniform(c)
+	for _, region := range regions {
+		r := region.Rect(bounds)
+		if r.Empty() {
+			continue
+		}
+		draw.Draw(outlined, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width), src, image.Point{}, draw.Over)
+		draw.Draw(outlined, image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y), src, image.Point{}, draw.Over)
+		draw.Draw(outlined, image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y), src, image.Point{}, draw.Over)
+		draw.Draw(outlined, image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y), src, image.Point{}, draw.Over)
+	}
+	return outlined
+}
+
+// RegionSelector lets the user drag out a rectangle over the preview image
+type RegionSelector struct {
+	widget.BaseWidget
+	imageSize  image.Point
+	start      fyne.Position
+	dragging   bool
+	box        *canvas.Rectangle
+	onSelected func(PercentRect)
+	onCancel   func()
+}
+
+// NewRegionSelector creates a new region selector
+func NewRegionSelector() *RegionSelector {
+	box := canvas.NewRectangle(color.Transparent)
+	box.StrokeColor = color.RGBA{R: 255, G: 64, B: 64, A: 255}
+	box.StrokeWidth = 2
+	box.Hide()
+
+	rs := &RegionSelector{box: box}
+	rs.ExtendBaseWidget(rs)
+	return rs
+}
+
+// CreateRenderer implements fyne.Widget
+func (rs *RegionSelector) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewWithoutLayout(rs.box))
+}
+
+// Select arms the selector; onSelected is called once with the next dragged
+// region, or onCancel if the drag didn't mark out a region on the image
+func (rs *RegionSelector) Select(onSelected func(PercentRect), onCancel func()) {
+	rs.onSelected = onSelected
+	rs.onCancel = onCancel
+}
+
+// SetImageSize records the pixel size of the image being previewed
+func (rs *RegionSelector) SetImageSize(size image.Point) {
+	rs.imageSize = size
+}
+
+// Dragged implements fyne.Draggable
+func (rs *RegionSelector) Dragged(ev *fyne.DragEvent) {
+	if rs.onSelected == nil {
+		return
+	}
+	if !rs.dragging {
+		rs.start = ev.Position.Subtract(ev.Dragged)
+		rs.dragging = true
+		rs.box.Show()
+	}
+
+	rs.box.Move(fyne.NewPos(
+		float32(math.Min(float64(rs.start.X), float64(ev.Position.X))),
+		float32(math.Min(float64(rs.start.Y), float64(ev.Position.Y))),
+	))
+	rs.box.Resize(fyne.NewSize(
+		float32(math.Abs(float64(ev.Position.X-rs.start.X))),
+		float32(math.Abs(float64(ev.Position.Y-rs.start.Y))),
+	))
+	rs.box.Refresh()
+}
+
+// DragEnd implements fyne.Draggable
+func (rs *RegionSelector) DragEnd() {
+	if !rs.dragging {
+		return
+	}
+	rs.dragging = false
+	rs.box.Hide()
+
+	region, ok := rs.toPercent(rs.box.Position(), rs.box.Size())
+	onSelected, onCancel := rs.onSelected, rs.onCancel
+	rs.onSelected, rs.onCancel = nil, nil
+	switch {
+	case ok && onSelected != nil:
+		onSelected(region)
+	case onCancel != nil:
+		onCancel()
+	}
+}
+
+// toPercent converts a widget-space rectangle to percent of the displayed image.
+// The preview uses ImageFillContain, so the image is centered and letterboxed.
+func (rs *RegionSelector) toPercent(pos fyne.Position, size fyne.Size) (PercentRect, bool) {
+	if rs.imageSize.X == 0 || rs.imageSize.Y == 0 {
+		return PercentRect{}, false
+	}
+
+	area := rs.Size()
+	scale := math.Min(float64(area.Width)/float64(rs.imageSize.X), float64(area.Height)/float64(rs.imageSize.Y))
+	shownW := float64(rs.imageSize.X) * scale
+	shownH := float64(rs.imageSize.Y) * scale
+	offsetX := (float64(area.Width) - shownW) / 2
+	offsetY := (float64(area.Height) - shownH) / 2
+
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(100, v))
+	}
+	x0 := clamp((float64(pos.X) - offsetX) / shownW * 100)
+	y0 := clamp((float64(pos.Y) - offsetY) / shownH * 100)
+	x1 := clamp((float64(pos.X+size.Width) - offsetX) / shownW * 100)
+	y1 := clamp((float64(pos.Y+size.Height) - offsetY) / shownH * 100)
+
+	if x1-x0 < 0.5 || y1-y0 < 0.5 {
+		return PercentRect{}, false
+	}
+	return PercentRect{X: x0, Y: y0, W: x1 - x0, H: y1 - y0}, true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+// exportRegistryName is the file listing every export carrying an invisible mark
+const exportRegistryName = "known_exports.json"
+
+// appDataDir returns the folder the app keeps its data in. Command line runs
+// have no fyne app, so they use the same folder fyne uses on desktops.
+func appDataDir() string {
+	if a := fyne.CurrentApp(); a != nil {
+		return a.Storage().RootURI().Path()
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+	return filepath.Join(configDir, "fyne", "com.watermark.app")
+}
+
+// ExportRecord describes one exported file and the IDs hidden in it
+type ExportRecord struct {
+	OwnerID   uint32
+	ImageID   uint32
+	Recipient string
+	Source    string
+	Output    string
+	Exported  time.Time
+	Vars      map[string]string
+}
+
+// ExportRegistry is the list of known exports
+type ExportRegistry struct {
+	Records []ExportRecord
+}
+
+// loadExportRegistry reads the registry, returning an empty one if there is none
+func loadExportRegistry() *ExportRegistry {
+	r := &ExportRegistry{}
+	data, err := os.ReadFile(filepath.Join(appDataDir(), exportRegistryName))
+	if err != nil {
+		return r
+	}
+	json.Unmarshal(data, &r.Records)
+	return r
+}
+
+// Add records an exported image that carries an invisible mark
+func (r *ExportRegistry) Add(ctx *ImageContext) {
+	if !ctx.Marked {
+		return
+	}
+	r.Records = append(r.Records, ExportRecord{
+		OwnerID:   appData.Invisible.OwnerID,
+		ImageID:   ctx.MarkID,
+		Recipient: ctx.Recipient,
+		Source:    ctx.Path,
+		Output:    ctx.OutputPath,
+		Exported:  time.Now(),
+		Vars:      ctx.Vars,
+	})
+}
+
+// Find returns the exports carrying the given IDs
+func (r *ExportRegistry) Find(payload markPayload) []ExportRecord {
+	var found []ExportRecord
+	for _, record := range r.Records {
+		if record.OwnerID == payload.Owner && record.ImageID == payload.Image {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// NewImageID returns a random image ID that no recorded export of the
+// owner uses
+func (r *ExportRegistry) NewImageID(owner uint32) uint32 {
+	used := map[uint32]bool{0: true}
+	for _, record := range r.Records {
+		if record.OwnerID == owner {
+			used[record.ImageID] = true
+		}
+	}
+	var b [4]byte
+	for {
+		rand.Read(b[:])
+		if id := binary.BigEndian.Uint32(b[:]); !used[id] {
+			return id
+		}
+	}
+}
+
+// Save writes the registry to the app data folder
+func (r *ExportRegistry) Save() error {
+	dir := appDataDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r.Records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, exportRegistryName), data, 0644)
+}
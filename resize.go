@@ -0,0 +1,140 @@
+package main
+
+import (
+	"image"
+	"math"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// ResizeConfig scales the image before the watermark is applied so the mark
+// stays crisp at the final size
+type ResizeConfig struct {
+	Mode     string  // "none", "percent", "fit", "width", "height" or "long-edge"
+	Percent  float64 // for "percent"
+	Width    int     // for "fit" and "width"
+	Height   int     // for "fit" and "height"
+	LongEdge int     // for "long-edge"
+	Filter   string
+}
+
+// resizeFilterNames lists the selectable resampling filters
+var resizeFilterNames = []string{"Lanczos", "CatmullRom", "MitchellNetravali", "Linear", "Box", "NearestNeighbor"}
+
+// resizeFilters maps the filter names to the imaging filters
+var resizeFilters = map[string]imaging.ResampleFilter{
+	"Lanczos":           imaging.Lanczos,
+	"CatmullRom":        imaging.CatmullRom,
+	"MitchellNetravali": imaging.MitchellNetravali,
+	"Linear":            imaging.Linear,
+	"Box":               imaging.Box,
+	"NearestNeighbor":   imaging.NearestNeighbor,
+}
+
+// resizedSize returns the output size for an image of w x h pixels
+func resizedSize(w, h int) (int, int) {
+	cfg := appData.Resize
+	scale := 1.0
+	switch cfg.Mode {
+	case "percent":
+		if cfg.Percent > 0 {
+			scale = cfg.Percent / 100
+		}
+	case "fit":
+		// Only shrink, like imaging.Fit
+		if cfg.Width > 0 && cfg.Height > 0 {
+			scale = math.Min(1, math.Min(float64(cfg.Width)/float64(w), float64(cfg.Height)/float64(h)))
+		}
+	case "width":
+		if cfg.Width > 0 {
+			scale = float64(cfg.Width) / float64(w)
+		}
+	case "height":
+		if cfg.Height > 0 {
+			scale = float64(cfg.Height) / float64(h)
+		}
+	case "long-edge":
+		if cfg.LongEdge > 0 {
+			scale = float64(cfg.LongEdge) / float64(max(w, h))
+		}
+	}
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+}
+
+// applyResize resizes the image according to the output settings
+func applyResize(img image.Image, ctx *ImageContext) image.Image {
+	bounds := img.Bounds()
+	w, h := resizedSize(bounds.Dx(), bounds.Dy())
+	if w == bounds.Dx() && h == bounds.Dy() {
+		return img
+	}
+
+	filter, ok := resizeFilters[appData.Resize.Filter]
+	if !ok {
+		filter = imaging.Lanczos
+	}
+	ctx.Logf("resized %dx%d to %dx%d (%s, %s)", bounds.Dx(), bounds.Dy(), w, h, appData.Resize.Mode, appData.Resize.Filter)
+	return imaging.Resize(img, w, h, filter)
+}
+
+// createResizeControls creates the output resize settings
+func createResizeControls() *fyne.Container {
+	intEntry := func(value int, set func(int)) *widget.Entry {
+		entry := widget.NewEntry()
+		entry.SetText(strconv.Itoa(value))
+		entry.OnChanged = func(text string) {
+			if v, err := strconv.Atoi(text); err == nil && v > 0 {
+				set(v)
+				updatePreview()
+			}
+		}
+		return entry
+	}
+
+	modeSelect := widget.NewSelect([]string{"none", "percent", "fit", "width", "height", "long-edge"}, func(value string) {
+		appData.Resize.Mode = value
+		updatePreview()
+	})
+	modeSelect.SetSelected(appData.Resize.Mode)
+
+	// Size scaling controls
+	scaleEntry := widget.NewEntry()
+	scaleEntry.SetText(strconv.FormatFloat(appData.Resize.Percent, 'f', -1, 64))
+	scaleEntry.OnChanged = func(text string) {
+		if percent, err := strconv.ParseFloat(text, 64); err == nil && percent > 0 && percent <= 1000 {
+			appData.Resize.Percent = percent
+			updatePreview()
+		}
+	}
+
+	widthEntry := intEntry(appData.Resize.Width, func(v int) { appData.Resize.Width = v })
+	heightEntry := intEntry(appData.Resize.Height, func(v int) { appData.Resize.Height = v })
+	longEdgeEntry := intEntry(appData.Resize.LongEdge, func(v int) { appData.Resize.LongEdge = v })
+
+	filterSelect := widget.NewSelect(resizeFilterNames, func(value string) {
+		appData.Resize.Filter = value
+		updatePreview()
+	})
+	filterSelect.SetSelected(appData.Resize.Filter)
+
+	return container.NewVBox(
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Resize Mode:"),
+			modeSelect,
+			widget.NewLabel("Scale (%):"),
+			scaleEntry,
+			widget.NewLabel("Max/Exact Width (px):"),
+			widthEntry,
+			widget.NewLabel("Max/Exact Height (px):"),
+			heightEntry,
+			widget.NewLabel("Long Edge (px):"),
+			longEdgeEntry,
+			widget.NewLabel("Filter:"),
+			filterSelect,
+		),
+	)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// ReversibleConfig stores the pixels under the visible mark, encrypted, so
+// the original can be restored with the key
+type ReversibleConfig struct {
+	Enabled bool
+	Key     string
+	Sidecar bool // write NAME.wmrestore next to the output instead of embedding
+}
+
+const (
+	// reversibleMagic starts the stored data, followed by a version byte
+	reversibleMagic   = "WMRV"
+	reversibleVersion = 1
+
+	// reversibleIterations is the PBKDF2-SHA256 work factor for the key
+	reversibleIterations = 200000
+
+	reversibleSaltSize   = 16
+	reversibleSidecarExt = ".wmrestore"
+
+	// jpegMCU is the JPEG block size with chroma subsampling. Blocks touched
+	// by the mark also show its compression ringing, so they're stored whole.
+	jpegMCU = 16
+)
+
+// pbkdf2SHA256 derives a key from a password as specified in RFC 8018
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// reversibleCipher returns the AES-256-GCM cipher for a key and salt
+func reversibleCipher(key string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(key), salt, reversibleIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// changedBounds returns the smallest rectangle holding every pixel that
+// differs between two images of the same size
+func changedBounds(a, b *image.NRGBA) image.Rectangle {
+	var changed image.Rectangle
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		rowA := a.Pix[y*a.Stride : y*a.Stride+w*4]
+		rowB := b.Pix[y*b.Stride : y*b.Stride+w*4]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		first, last := 0, w-1
+		for first < w && bytes.Equal(rowA[first*4:first*4+4], rowB[first*4:first*4+4]) {
+			first++
+		}
+		for last > first && bytes.Equal(rowA[last*4:last*4+4], rowB[last*4:last*4+4]) {
+			last--
+		}
+		changed = changed.Union(image.Rect(first, y, last+1, y+1))
+	}
+	return changed
+}
+
+// captureReversible encrypts the pixels the marks changed. base is the image
+// before any mark was applied and marked the final pixels.
+func captureReversible(base, marked image.Image, ctx *ImageContext) ([]byte, error) {
+	cfg := appData.Reversible
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Key == "" {
+		return nil, errors.New("reversible mode needs a key")
+	}
+
+	original := imaging.Clone(base)
+	rect := changedBounds(original, imaging.Clone(marked))
+	if rect.Empty() {
+		return nil, nil
+	}
+	if appData.OutputFormat != "PNG" {
+		rect = image.Rect(rect.Min.X/jpegMCU*jpegMCU, rect.Min.Y/jpegMCU*jpegMCU,
+			(rect.Max.X+jpegMCU-1)/jpegMCU*jpegMCU, (rect.Max.Y+jpegMCU-1)/jpegMCU*jpegMCU).Intersect(original.Bounds())
+	}
+
+	// The size and area are encrypted with the pixels
+	var plain bytes.Buffer
+	for _, v := range []int{original.Bounds().Dx(), original.Bounds().Dy(), rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y} {
+		binary.Write(&plain, binary.BigEndian, uint32(v))
+	}
+	if err := png.Encode(&plain, original.SubImage(rect)); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(reversibleMagic), reversibleVersion)
+	salt := make([]byte, reversibleSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := reversibleCipher(cfg.Key, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := append(append(append([]byte(nil), header...), salt...), nonce...)
+	data = aead.Seal(data, nonce, plain.Bytes(), header)
+	ctx.Logf("reversible: stored %dx%d pixels at %d,%d (%d bytes)", rect.Dx(), rect.Dy(), rect.Min.X, rect.Min.Y, len(data))
+	if invisibleMarkEnabled() || appData.Fragile.Enabled {
+		ctx.Logf("reversible: invisible and fragile marks change pixels everywhere, so the restore data covers them too")
+	}
+	return data, nil
+}
+
+// saveReversibleSidecar writes the restore data next to the output when
+// asked to, or when it couldn't be embedded
+func saveReversibleSidecar(outputPath string, data []byte, embedded bool, ctx *ImageContext) error {
+	if len(data) == 0 || embedded {
+		return nil
+	}
+	if !appData.Reversible.Sidecar {
+		ctx.Warnf("restore data could not be embedded, wrote a sidecar file instead")
+	}
+	ctx.Sidecars = append(ctx.Sidecars, outputPath+reversibleSidecarExt)
+	return os.WriteFile(outputPath+reversibleSidecarExt, data, 0644)
+}
+
+// restoreImage removes the visible mark from a file using the stored pixels.
+// It returns the restored image and the metadata to write with it.
+func restoreImage(path, key string) (image.Image, *Metadata, error) {
+	md, err := readMetadata(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := md.Restore
+	if len(data) == 0 {
+		if data, err = os.ReadFile(path + reversibleSidecarExt); err != nil {
+			return nil, nil, errors.New("no restore data found")
+		}
+	}
+
+	headerSize := len(reversibleMagic) + 1
+	if len(data) < headerSize+reversibleSaltSize || string(data[:len(reversibleMagic)]) != reversibleMagic {
+		return nil, nil, errors.New("restore data is damaged")
+	}
+	if data[len(reversibleMagic)] != reversibleVersion {
+		return nil, nil, fmt.Errorf("restore data version %d is not supported", data[len(reversibleMagic)])
+	}
+	header, salt := data[:headerSize], data[headerSize:headerSize+reversibleSaltSize]
+	aead, err := reversibleCipher(key, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	rest := data[headerSize+reversibleSaltSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, nil, errors.New("restore data is damaged")
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, nil, errors.New("wrong key, or the restore data was altered")
+	}
+
+	if len(plain) < 24 {
+		return nil, nil, errors.New("restore data is damaged")
+	}
+	var v [6]int
+	for i := range v {
+		v[i] = int(binary.BigEndian.Uint32(plain[i*4:]))
+	}
+	patch, err := png.Decode(bytes.NewReader(plain[24:]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := imaging.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	restored := imaging.Clone(img)
+	if restored.Bounds().Dx() != v[0] || restored.Bounds().Dy() != v[1] {
+		return nil, nil, fmt.Errorf("image is %dx%d but was exported at %dx%d",
+			restored.Bounds().Dx(), restored.Bounds().Dy(), v[0], v[1])
+	}
+	rect := image.Rect(v[2], v[3], v[4], v[5])
+	if !rect.In(restored.Bounds()) || rect.Size() != patch.Bounds().Size() {
+		return nil, nil, errors.New("restore data is damaged")
+	}
+
+	// Copy the bytes, drawing would round translucent pixels
+	pixels := imaging.Clone(patch)
+	for y := 0; y < rect.Dy(); y++ {
+		copy(restored.Pix[restored.PixOffset(rect.Min.X, rect.Min.Y+y):], pixels.Pix[y*pixels.Stride:y*pixels.Stride+rect.Dx()*4])
+	}
+
+	// The restored file describes different pixels, and its preview must
+	// not show the mark
+	md.Restore, md.Provenance = nil, nil
+	applyThumbnail(md, restored, nil)
+	return restored, md, nil
+}
+
+// restoredPath is where the restored copy of a file is written by default
+func restoredPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_restored" + ext
+}
+
+// writeRestored restores a file and writes the result in the same format
+func writeRestored(path, outputPath, key string) error {
+	img, md, err := restoreImage(path, key)
+	if err != nil {
+		return err
+	}
+	format := "JPEG"
+	if strings.EqualFold(filepath.Ext(outputPath), ".png") {
+		format = "PNG"
+	}
+
+	// JPEG files are saved at high quality to lose little more detail
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, format, 95, md); err != nil {
+		buf.Reset()
+		if err := encodeImage(&buf, img, format, 95, nil); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(outputPath, buf.Bytes(), 0644)
+}
+
+// restoreCommand implements "restore -key KEY [-o OUT] FILE..."
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("WATERMARK_RESTORE_KEY"), "key the file was exported with (or $WATERMARK_RESTORE_KEY)")
+	out := flags.String("o", "", "output file, only with a single input (default: NAME_restored next to it)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: watermark-app restore -key KEY [-o OUT] IMAGE...")
+		fmt.Fprintln(flags.Output(), "PNG files are restored exactly, JPEG files as closely as recompression allows.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *key == "" || flags.NArg() == 0 || (*out != "" && flags.NArg() > 1) {
+		flags.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		outputPath := *out
+		if outputPath == "" {
+			outputPath = restoredPath(path)
+		}
+		if err := writeRestored(path, outputPath, *key); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s -> %s\n", path, outputPath)
+	}
+	return code
+}
+
+// ShowRestoreDialog asks for a file exported in reversible mode and the key,
+// and writes the restored copy next to it
+func (ec *EnhancedControls) ShowRestoreDialog() {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, ec.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		reader.Close()
+		path := reader.URI().Path()
+
+		keyEntry := widget.NewPasswordEntry()
+		keyEntry.SetText(appData.Reversible.Key)
+		dialog.ShowForm("Restore "+filepath.Base(path), "Restore", "Cancel",
+			[]*widget.FormItem{widget.NewFormItem("Key", keyEntry)}, func(ok bool) {
+				if !ok {
+					return
+				}
+				if keyEntry.Text == "" {
+					dialog.ShowError(errors.New("Error: Key cannot be empty"), ec.window)
+					return
+				}
+				outputPath := restoredPath(path)
+				if err := writeRestored(path, outputPath, keyEntry.Text); err != nil {
+					dialog.ShowError(err, ec.window)
+					return
+				}
+				dialog.ShowInformation("Restored", "Wrote "+outputPath, ec.window)
+			}, ec.window)
+	}, ec.window)
+}
+
+// createReversibleControls creates the reversible watermark settings
+func (ec *EnhancedControls) createReversibleControls() *fyne.Container {
+	cfg := &appData.Reversible
+
+	enableCheck := widget.NewCheck("Store the covered pixels so the mark can be removed", func(checked bool) {
+		cfg.Enabled = checked
+	})
+	enableCheck.SetChecked(cfg.Enabled)
+
+	keyEntry := widget.NewPasswordEntry()
+	keyEntry.SetPlaceHolder("Unlock key")
+	keyEntry.SetText(cfg.Key)
+	keyEntry.OnChanged = func(text string) {
+		cfg.Key = text
+	}
+
+	sidecarCheck := widget.NewCheck("Write to a "+reversibleSidecarExt+" file instead of the image", func(checked bool) {
+		cfg.Sidecar = checked
+	})
+	sidecarCheck.SetChecked(cfg.Sidecar)
+
+	return container.NewVBox(
+		widget.NewLabel("Reversible Watermark"),
+		widget.NewSeparator(),
+		enableCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Key:"),
+			keyEntry,
+		),
+		sidecarCheck,
+		widget.NewLabel("The pixels are encrypted with the key (AES-256-GCM). PNG exports\n"+
+			"restore exactly; JPEG exports restore as closely as recompression allows.\n"+
+			"Invisible and fragile marks change the whole image, which makes the data larger."),
+		widget.NewButton("Restore Image...", func() {
+			ec.ShowRestoreDialog()
+		}),
+	)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"html"
+	"regexp"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// EXIF tags for the rights fields
+const (
+	tagArtist    = 0x013B
+	tagCopyright = 0x8298
+)
+
+// RightsConfig holds the copyright notice written into exported files
+type RightsConfig struct {
+	Copyright  string
+	Creator    string
+	ContactURL string
+	UsageTerms string
+
+	// UseWatermarkText uses the watermark text as the copyright notice
+	UseWatermarkText bool
+}
+
+// copyrightNotice returns the notice to embed
+func (rc RightsConfig) copyrightNotice() string {
+	if rc.UseWatermarkText && strings.TrimSpace(appData.Watermark.Text) != "" {
+		return appData.Watermark.Text
+	}
+	return rc.Copyright
+}
+
+// isEmpty reports whether there is nothing to embed
+func (rc RightsConfig) isEmpty() bool {
+	return rc.copyrightNotice() == "" && rc.Creator == "" && rc.ContactURL == "" && rc.UsageTerms == ""
+}
+
+// xmpRightsProperties matches the properties replaced by applyRights, in
+// element and attribute form
+var xmpRightsProperties = regexp.MustCompile(
+	`(?s)<(dc:rights|dc:creator|xmpRights:WebStatement|xmpRights:UsageTerms|xmpRights:Marked)\b[^>]*?(?:/>|>.*?</(?:dc:rights|dc:creator|xmpRights:WebStatement|xmpRights:UsageTerms|xmpRights:Marked)>)` +
+		`|\s(?:xmpRights:WebStatement|xmpRights:Marked)="[^"]*"`)
+
+// applyRights writes the copyright and creator into the EXIF, XMP and PNG
+// text metadata
+func applyRights(md *Metadata, ctx *ImageContext) {
+	rights := appData.Watermark.Rights
+	if rights.isEmpty() {
+		return
+	}
+	notice := expandTokens(rights.copyrightNotice(), ctx)
+	rights.Creator = expandTokens(rights.Creator, ctx)
+	rights.ContactURL = expandTokens(rights.ContactURL, ctx)
+	rights.UsageTerms = expandTokens(rights.UsageTerms, ctx)
+
+	// EXIF Copyright and Artist
+	exif := &exifData{order: binary.BigEndian}
+	if len(md.Exif) > 0 {
+		if parsed, err := parseExif(md.Exif); err == nil {
+			exif = parsed
+		}
+	}
+	setASCII := func(tag uint16, name, value string) {
+		if value == "" {
+			return
+		}
+		text, replaced := exifText(value)
+		if replaced {
+			ctx.Logf("EXIF %s holds ASCII only, wrote %q; XMP keeps the full text", name, text)
+		}
+		setExifEntry(&exif.ifd0, asciiEntry(tag, text))
+	}
+	setASCII(tagCopyright, "Copyright", notice)
+	setASCII(tagArtist, "Artist", rights.Creator)
+	md.Exif = exif.encode()
+
+	// XMP dc and xmpRights
+	md.XMP = rightsXMP(md.XMP, rights, notice)
+
+	// PNG text chunks, written when the output is PNG
+	setText := func(keyword, text string) {
+		if text == "" {
+			return
+		}
+		for i := range md.Text {
+			if md.Text[i].Keyword == keyword {
+				md.Text[i].Text = text
+				return
+			}
+		}
+		md.Text = append(md.Text, pngText{keyword, text})
+	}
+	setText("Copyright", notice)
+	setText("Author", rights.Creator)
+	setText("Contact URL", rights.ContactURL)
+	setText("Usage Terms", rights.UsageTerms)
+
+	ctx.Logf("embedded rights: copyright=%q creator=%q", notice, rights.Creator)
+}
+
+// exifText makes value fit an EXIF ASCII field, replacing "©" with "(c)" and
+// other characters outside 7-bit ASCII with "?". It reports whether anything
+// was replaced.
+func exifText(value string) (string, bool) {
+	replaced := false
+	text := strings.Map(func(r rune) rune {
+		if r < 0x80 {
+			return r
+		}
+		replaced = true
+		return '?'
+	}, strings.ReplaceAll(value, "©", "(c)"))
+	return text, replaced || strings.Contains(value, "©")
+}
+
+// rightsXMP adds a description with the rights properties to an XMP packet,
+// creating the packet when there is none
+func rightsXMP(xmp []byte, rights RightsConfig, notice string) []byte {
+	var desc strings.Builder
+	desc.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/">`)
+	if notice != "" {
+		desc.WriteString(`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">` + html.EscapeString(notice) + `</rdf:li></rdf:Alt></dc:rights>`)
+		desc.WriteString(`<xmpRights:Marked>True</xmpRights:Marked>`)
+	}
+	if rights.Creator != "" {
+		desc.WriteString(`<dc:creator><rdf:Seq><rdf:li>` + html.EscapeString(rights.Creator) + `</rdf:li></rdf:Seq></dc:creator>`)
+	}
+	if rights.ContactURL != "" {
+		desc.WriteString(`<xmpRights:WebStatement>` + html.EscapeString(rights.ContactURL) + `</xmpRights:WebStatement>`)
+	}
+	if rights.UsageTerms != "" {
+		desc.WriteString(`<xmpRights:UsageTerms><rdf:Alt><rdf:li xml:lang="x-default">` + html.EscapeString(rights.UsageTerms) + `</rdf:li></rdf:Alt></xmpRights:UsageTerms>`)
+	}
+	desc.WriteString(`</rdf:Description>`)
+
+	packet := string(xmp)
+	if !strings.Contains(packet, "</rdf:RDF>") {
+		return []byte(`<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+			`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			desc.String() + `</rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+	}
+
+	// Drop the old values so each property appears once
+	packet = xmpRightsProperties.ReplaceAllString(packet, "")
+	end := strings.LastIndex(packet, "</rdf:RDF>")
+	return []byte(packet[:end] + desc.String() + packet[end:])
+}
+
+// createRightsControls creates the copyright metadata settings
+func createRightsControls() *fyne.Container {
+	rights := &appData.Watermark.Rights
+	entry := func(placeholder string, value *string) *widget.Entry {
+		e := widget.NewEntry()
+		e.SetPlaceHolder(placeholder)
+		e.SetText(*value)
+		e.OnChanged = func(text string) {
+			*value = text
+		}
+		return e
+	}
+
+	copyrightEntry := entry("© 2024 Your Name", &rights.Copyright)
+	useTextCheck := widget.NewCheck("Use watermark text as copyright", func(checked bool) {
+		rights.UseWatermarkText = checked
+		if checked {
+			copyrightEntry.Disable()
+		} else {
+			copyrightEntry.Enable()
+		}
+	})
+	useTextCheck.SetChecked(rights.UseWatermarkText)
+
+	return container.NewVBox(
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Copyright:"),
+			copyrightEntry,
+			widget.NewLabel("Creator:"),
+			entry("Photographer name", &rights.Creator),
+			widget.NewLabel("Contact URL:"),
+			entry("https://example.com", &rights.ContactURL),
+			widget.NewLabel("Usage Terms:"),
+			entry("All rights reserved", &rights.UsageTerms),
+		),
+		useTextCheck,
+	)
+}
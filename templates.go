@@ -55,10 +55,22 @@ func (tm *TemplateManager) SaveTemplate() {
 			Rotation:  appData.Watermark.Rotation,
 			ImagePath: appData.Watermark.ImagePath,
 			IsImage:   appData.Watermark.IsImage,
+			Type:      appData.Watermark.Type,
+			QR:        appData.Watermark.QR,
+			Barcode:   appData.Watermark.Barcode,
+
+			AutoUseManual:  appData.Watermark.AutoUseManual,
+			ExclusionZones: append([]PercentRect(nil), appData.Watermark.ExclusionZones...),
+			Jitter:         appData.Watermark.Jitter,
+			Warp:           appData.Watermark.Warp,
+			Knockout:       appData.Watermark.Knockout,
+			Redactions:     append([]Redaction(nil), appData.Watermark.Redactions...),
+			Rights:         appData.Watermark.Rights,
 		}
 
 		tm.templates[name] = template
 		tm.saveTemplatesToFile()
+		appData.TemplateName = name
 
 		dialog.ShowInformation("Success", "Template saved", tm.window)
 	}, tm.window)
@@ -96,8 +108,12 @@ func (tm *TemplateManager) LoadTemplate() {
 			return
 		}
 
-		// Apply template to current watermark config
+		// Apply template to current watermark config. The slices are copied so
+		// editing the zones doesn't change the saved template.
 		appData.Watermark = *template
+		appData.Watermark.ExclusionZones = append([]PercentRect(nil), template.ExclusionZones...)
+		appData.Watermark.Redactions = append([]Redaction(nil), template.Redactions...)
+		appData.TemplateName = selectedName
 
 		dialog.ShowInformation("Success", "模板已Load", tm.window)
 	}, tm.window)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"regexp"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// thumbnailSize bounds regenerated EXIF thumbnails, as cameras do
+	thumbnailSize = 160
+
+	// thumbnailMaxDifference is the mean gray level difference (0-255) above
+	// which an embedded preview is reported as not matching the image
+	thumbnailMaxDifference = 12.0
+
+	tagCompression = 0x0103
+)
+
+// xmpThumbnails matches thumbnails embedded in XMP as base64
+var xmpThumbnails = regexp.MustCompile(`(?s)<xmp:Thumbnails\b.*?</xmp:Thumbnails>|<xmp:Thumbnails\b[^>]*/>`)
+
+// applyThumbnail replaces the embedded EXIF thumbnail with one made from the
+// exported image, or drops it, so the original photo can't leak through it
+func applyThumbnail(md *Metadata, img image.Image, ctx *ImageContext) {
+	if xmpThumbnails.Match(md.XMP) {
+		md.XMP = xmpThumbnails.ReplaceAll(md.XMP, nil)
+		ctx.Logf("removed XMP thumbnail")
+	}
+
+	if len(md.Exif) == 0 {
+		return
+	}
+	exif, err := parseExif(md.Exif)
+	if err != nil || len(exif.thumbnail) == 0 {
+		return
+	}
+
+	if appData.ExifThumbnail == "drop" {
+		exif.ifd1, exif.thumbnail = nil, nil
+		md.Exif = exif.encode()
+		ctx.Logf("dropped EXIF thumbnail")
+		return
+	}
+
+	var buf bytes.Buffer
+	thumb := imaging.Fit(img, thumbnailSize, thumbnailSize, imaging.Box)
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		exif.ifd1, exif.thumbnail = nil, nil
+		ctx.Logf("dropped EXIF thumbnail: %v", err)
+	} else {
+		exif.thumbnail = buf.Bytes()
+		setExifEntry(&exif.ifd1, exif.shortEntry(tagCompression, 6)) // JPEG
+		ctx.Logf("regenerated EXIF thumbnail (%dx%d)", thumb.Bounds().Dx(), thumb.Bounds().Dy())
+	}
+	md.Exif = exif.encode()
+}
+
+// verifyThumbnail checks that the preview embedded in an exported file shows
+// the same picture as the file itself
+func verifyThumbnail(outputPath string, ctx *ImageContext) {
+	md, err := readMetadata(outputPath)
+	if err != nil || len(md.Exif) == 0 {
+		return
+	}
+	exif, err := parseExif(md.Exif)
+	if err != nil || len(exif.thumbnail) == 0 {
+		return
+	}
+
+	visible, err := imaging.Open(outputPath)
+	if err != nil {
+		return
+	}
+	thumb, _, err := image.Decode(bytes.NewReader(exif.thumbnail))
+	if err != nil {
+		ctx.Warnf("embedded thumbnail can't be decoded: %v", err)
+		return
+	}
+
+	if diff := thumbnailDifference(thumb, visible); diff > thumbnailMaxDifference {
+		ctx.Warnf("embedded thumbnail differs from the visible image (mean difference %.1f)", diff)
+	}
+}
+
+// thumbnailDifference returns the mean gray level difference between a
+// thumbnail and the image scaled to the same size
+func thumbnailDifference(thumb, img image.Image) float64 {
+	size := thumb.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return math.Inf(1)
+	}
+	a := imaging.Grayscale(thumb)
+	b := imaging.Grayscale(imaging.Resize(img, size.X, size.Y, imaging.Box))
+
+	total := 0.0
+	for i := 0; i < len(a.Pix); i += 4 {
+		total += math.Abs(float64(a.Pix[i]) - float64(b.Pix[i]))
+	}
+	return total / float64(size.X*size.Y)
+}
+
+// createThumbnailControls creates the EXIF thumbnail setting
+func createThumbnailControls() *fyne.Container {
+	thumbnailSelect := widget.NewSelect([]string{"regenerate", "drop"}, func(value string) {
+		appData.ExifThumbnail = value
+	})
+	thumbnailSelect.SetSelected(appData.ExifThumbnail)
+
+	return container.NewGridWithColumns(2,
+		widget.NewLabel("EXIF Thumbnail:"),
+		thumbnailSelect,
+	)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenPattern matches {name} and {name:format} placeholders. Names can hold
+// anything but braces and colons so any manifest header works as a token.
+var tokenPattern = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]*))?\}`)
+
+// tokenHelp lists the tokens for the UI
+const tokenHelp = "Tokens: {filename} {name} {folder} {index} {total} {row} {width} {height} {date:2006-01-02} {time:15:04} {exif.Artist} {exif.DateTimeOriginal:2006}"
+
+// exifDateLayout is how EXIF stores dates and times
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// expandTokens replaces the placeholders in text with the values for the
+// image being processed. Unknown placeholders are left as they are.
+func expandTokens(text string, ctx *ImageContext) string {
+	if ctx == nil || !strings.Contains(text, "{") {
+		return text
+	}
+
+	return tokenPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := tokenPattern.FindStringSubmatch(match)
+		name, format := parts[1], parts[2]
+
+		// Manifest columns take precedence over the built-in tokens
+		if value, ok := ctx.Vars[name]; ok {
+			return value
+		}
+
+		if exifName, ok := strings.CutPrefix(name, "exif."); ok {
+			if value, ok := ctx.exifValue(exifName, format); ok {
+				return value
+			}
+			return ""
+		}
+
+		switch name {
+		case "filename":
+			return filepath.Base(ctx.Path)
+		case "name":
+			return strings.TrimSuffix(filepath.Base(ctx.Path), filepath.Ext(ctx.Path))
+		case "folder":
+			return filepath.Base(filepath.Dir(ctx.Path))
+		case "index":
+			return strconv.Itoa(ctx.Index + 1)
+		case "total":
+			return strconv.Itoa(ctx.Total)
+		case "row":
+			return strconv.Itoa(ctx.Row)
+		case "width":
+			return strconv.Itoa(ctx.Size.X)
+		case "height":
+			return strconv.Itoa(ctx.Size.Y)
+		case "date":
+			if format == "" {
+				format = "2006-01-02"
+			}
+			return time.Now().Format(format)
+		case "time":
+			if format == "" {
+				format = "15:04"
+			}
+			return time.Now().Format(format)
+		}
+		return match
+	})
+}
+
+// sourceExif returns the parsed EXIF block of the source image, reading it
+// on first use
+func (ctx *ImageContext) sourceExif() *exifData {
+	if !ctx.exifLoaded {
+		ctx.exifLoaded = true
+		if md, err := readMetadata(ctx.Path); err == nil && len(md.Exif) > 0 {
+			ctx.exif, _ = parseExif(md.Exif)
+		}
+	}
+	return ctx.exif
+}
+
+// exifValue formats the named EXIF tag of the source image. Dates are
+// reformatted when a Go time layout is given.
+func (ctx *ImageContext) exifValue(name, format string) (string, bool) {
+	exif := ctx.sourceExif()
+	if exif == nil {
+		return "", false
+	}
+
+	var entry exifEntry
+	found := false
+	for tag, tagName := range exifTagNames {
+		if strings.EqualFold(tagName, name) {
+			if entry, found = findExifEntry(exif.ifd0, tag); !found {
+				entry, found = findExifEntry(exif.exif, tag)
+			}
+			break
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	value := exif.formatValue(entry)
+	if format != "" {
+		if t, err := time.Parse(exifDateLayout, value); err == nil {
+			value = t.Format(format)
+		}
+	}
+	return value, true
+}
+
+// formatValue renders an entry's value as text
+func (e *exifData) formatValue(entry exifEntry) string {
+	if entry.Type == exifASCII || entry.Type == exifUndefined {
+		return strings.TrimSpace(strings.TrimRight(string(entry.Data), "\x00"))
+	}
+
+	size := exifTypeSizes[entry.Type]
+	var values []string
+	for i := 0; i+size <= len(entry.Data); i += size {
+		p := entry.Data[i:]
+		switch entry.Type {
+		case exifByte:
+			values = append(values, strconv.Itoa(int(p[0])))
+		case exifShort:
+			values = append(values, strconv.Itoa(int(e.order.Uint16(p))))
+		case exifSShort:
+			values = append(values, strconv.Itoa(int(int16(e.order.Uint16(p)))))
+		case exifLong:
+			values = append(values, strconv.FormatUint(uint64(e.order.Uint32(p)), 10))
+		case exifSLong:
+			values = append(values, strconv.Itoa(int(int32(e.order.Uint32(p)))))
+		case exifRational, exifSRational:
+			num, den := int64(e.order.Uint32(p)), int64(e.order.Uint32(p[4:]))
+			if entry.Type == exifSRational {
+				num, den = int64(int32(num)), int64(int32(den))
+			}
+			values = append(values, formatRational(num, den))
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// formatRational shows exposure times as fractions and other values as decimals
+func formatRational(num, den int64) string {
+	switch {
+	case den == 0:
+		return "0"
+	case num == 1 && den > 1:
+		return fmt.Sprintf("1/%d", den)
+	case num%den == 0:
+		return strconv.FormatInt(num/den, 10)
+	}
+	return strconv.FormatFloat(math.Round(float64(num)/float64(den)*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"math/rand"
+)
+
+// WarpConfig distorts the watermark layer with a smooth random wave field so
+// the mark is never pixel-identical across images
+type WarpConfig struct {
+	Enabled   bool
+	Seed      string
+	Amplitude float64 // maximum displacement in pixels
+	Frequency float64 // waves per 100 pixels
+}
+
+// warpWave is one plane wave of the displacement field
+type warpWave struct {
+	dirX, dirY float64
+	freq       float64
+	phase      float64
+	weight     float64
+}
+
+// warpLayer applies the configured wave distortion to the watermark layer
+func warpLayer(layer image.Image, ctx *ImageContext) image.Image {
+	cfg := appData.Watermark.Warp
+	if !cfg.Enabled || cfg.Amplitude <= 0 || cfg.Frequency <= 0 || codeWatermark() {
+		return layer
+	}
+
+	rng := rand.New(rand.NewSource(seedFor(ctx, "warp\x00"+cfg.Seed)))
+	waves := func() []warpWave {
+		waves := make([]warpWave, 3)
+		total := 0.0
+		for i := range waves {
+			angle := rng.Float64() * 2 * math.Pi
+			waves[i] = warpWave{
+				dirX:   math.Cos(angle),
+				dirY:   math.Sin(angle),
+				freq:   cfg.Frequency * (0.7 + 0.6*rng.Float64()) * 2 * math.Pi / 100,
+				phase:  rng.Float64() * 2 * math.Pi,
+				weight: 0.5 + rng.Float64(),
+			}
+			total += waves[i].weight
+		}
+		for i := range waves {
+			waves[i].weight /= total
+		}
+		return waves
+	}
+	wavesX := waves()
+	wavesY := waves()
+
+	displace := func(waves []warpWave, x, y float64) float64 {
+		d := 0.0
+		for _, w := range waves {
+			d += w.weight * math.Sin(w.freq*(w.dirX*x+w.dirY*y)+w.phase)
+		}
+		return d * cfg.Amplitude
+	}
+
+	// Work on premultiplied pixels so interpolation doesn't create fringes
+	b := layer.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), layer, b.Min, draw.Src)
+
+	// Pad so displaced pixels are not clipped
+	pad := int(math.Ceil(cfg.Amplitude))
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()+2*pad, b.Dy()+2*pad))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			fx := float64(x - pad)
+			fy := float64(y - pad)
+			sampleRGBA(src, fx-displace(wavesX, fx, fy), fy-displace(wavesY, fx, fy), dst.Pix[dst.PixOffset(x, y):])
+		}
+	}
+
+	ctx.Logf("warp amplitude=%.1f frequency=%.2f seed=%q", cfg.Amplitude, cfg.Frequency, cfg.Seed)
+	return dst
+}
+
+// sampleRGBA bilinearly samples src at (x, y) into the first four bytes of out
+func sampleRGBA(src *image.RGBA, x, y float64, out []uint8) {
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+	tx := x - float64(x0)
+	ty := y - float64(y0)
+
+	var acc [4]float64
+	for _, p := range [4]struct {
+		dx, dy int
+		w      float64
+	}{
+		{0, 0, (1 - tx) * (1 - ty)},
+		{1, 0, tx * (1 - ty)},
+		{0, 1, (1 - tx) * ty},
+		{1, 1, tx * ty},
+	} {
+		px, py := x0+p.dx, y0+p.dy
+		if p.w == 0 || px < 0 || py < 0 || px >= src.Rect.Dx() || py >= src.Rect.Dy() {
+			continue
+		}
+		i := src.PixOffset(px, py)
+		for c := 0; c < 4; c++ {
+			acc[c] += p.w * float64(src.Pix[i+c])
+		}
+	}
+	for c := 0; c < 4; c++ {
+		out[c] = uint8(math.Min(255, math.Round(acc[c])))
+	}
+}